@@ -0,0 +1,107 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// structured JSON audit log, one record per wrapped docker invocation
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	DefaultAuditLogFile = "/var/log/docker-wrapper-audit.log"
+
+	// env var to override the audit log file, "off" disables the audit log
+	AuditLogEnv = "DOCKER_WRAPPER_AUDIT_LOG"
+)
+
+const (
+	AuditDecisionAllowed = "allowed"
+	AuditDecisionDenied  = "denied"
+)
+
+// AuditRecord is a single line of the audit log
+type AuditRecord struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	Hostname      string             `json:"hostname"`
+	Pid           int                `json:"pid"`
+	Uid           int                `json:"uid"`
+	OriginalArgs  []string           `json:"original_args"`
+	FinalArgs     []string           `json:"final_args"`
+	Image         *imageref.ImageRef `json:"image,omitempty"`
+	MesosTaskId   string             `json:"mesos_task_id,omitempty"`
+	MarathonAppId string             `json:"marathon_app_id,omitempty"`
+	Modules       []moduleResult     `json:"modules,omitempty"`
+	Decision      string             `json:"decision"`
+	Reason        string             `json:"reason,omitempty"`
+	Version       string             `json:"version"`
+}
+
+// newAuditRecord collects the audit details of this invocation, the image
+// and mesos/marathon ids come from the run context (nil if not a docker run).
+// Args are redacted.
+func newAuditRecord(ctx *runmodule.RunContext, original []string, final []string, results []moduleResult) AuditRecord {
+	record := AuditRecord{
+		Timestamp:    time.Now().UTC(),
+		Hostname:     localHost.Hostname,
+		Pid:          os.Getpid(),
+		Uid:          os.Getuid(),
+		OriginalArgs: redactArgs(original),
+		FinalArgs:    redactArgs(final),
+		Modules:      redactModuleResults(results),
+		Decision:     AuditDecisionAllowed,
+		Version:      VERSION,
+	}
+	if ctx != nil {
+		record.MesosTaskId = ctx.MesosTaskId
+		record.MarathonAppId = ctx.MarathonAppId
+		if !ctx.Image.IsZero() {
+			image := ctx.Image
+			record.Image = &image
+		}
+	}
+	if denial := deniedResult(results); denial != nil {
+		record.Decision = AuditDecisionDenied
+		record.Reason = denial.Denied
+		record.FinalArgs = nil
+	}
+	return record
+}
+
+// writeAuditRecord appends the record as a line of JSON to the audit log.
+// Errors are logged, never fatal - we still want to exec docker.
+func writeAuditRecord(record AuditRecord) {
+	fileName := os.Getenv(AuditLogEnv)
+	if fileName == "off" {
+		return
+	}
+	if fileName == "" {
+		fileName = DefaultAuditLogFile
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("WARN: unable to encode audit record: %v", err)
+		return
+	}
+
+	auditFile, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		log.Printf("WARN: unable to open audit log: %v", err)
+		return
+	}
+	defer auditFile.Close()
+
+	// a single write per record keeps concurrent appends whole
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("WARN: unable to write audit log: %v", err)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// declarative docker-wrapper configuration - lets us inject run args without
+// writing and compiling a new RunModule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	// main config file and drop-in directory, *.json files in the directory
+	// are loaded in lexical order after the main file
+	DefaultConfigFile = "/etc/docker-wrapper/config.json"
+	DefaultConfigDir  = "/etc/docker-wrapper/conf.d"
+
+	// env vars to override the above locations
+	ConfigFileEnv = "DOCKER_WRAPPER_CONFIG"
+	ConfigDirEnv  = "DOCKER_WRAPPER_CONFIG_DIR"
+)
+
+// WrapperConfig is the top level of a docker-wrapper config file
+type WrapperConfig struct {
+	Rules       []ConfigRule            `json:"rules"`
+	Mirrors     []ImageMirror           `json:"mirrors"`
+	ImagePolicy ImagePolicy             `json:"image_policy"`
+	PinDigests  *DigestPinConfig        `json:"pin_digests"`
+	CpuQuota    *CpuQuotaConfig         `json:"cpu_quota"`
+	MemPolicy   *MemoryPolicyConfig     `json:"memory_policy"`
+	Limits      *ResourceLimitsConfig   `json:"resource_limits"`
+	Logging     *LoggingConfig          `json:"logging"`
+	Redaction   *RedactionConfig        `json:"redaction"`
+	ExecModules *ExecModuleConfig       `json:"exec_modules"`
+	Modules     map[string]ModuleConfig `json:"modules"`
+}
+
+// ModuleConfig overrides the failure handling of a run module by name (see
+// WrapperRunFailureHandler), adds to its ordering (see WrapperRunOrdering)
+// and turns it on or off (see runModuleSkipped)
+type ModuleConfig struct {
+	Timeout   string      `json:"timeout"`    // e.g. "2s"
+	OnFailure string      `json:"on_failure"` // allow or deny
+	After     []string    `json:"after"`
+	Before    []string    `json:"before"`
+	Enabled   *bool       `json:"enabled"` // default true
+	Enable    []RuleMatch `json:"enable"`  // runs where any matches, even if not enabled
+	Disable   []RuleMatch `json:"disable"` // never runs where any matches
+	OptOut    *bool       `json:"opt_out"` // containers may opt out, default true except for policies
+}
+
+// ConfigRule declares args to inject into a docker run when Match matches,
+// or a reason to deny the run.  Unless excludes runs from the rule, e.g. to
+// whitelist some Marathon apps.
+type ConfigRule struct {
+	Name     string     `json:"name"`
+	Priority int        `json:"priority"`
+	Match    RuleMatch  `json:"match"`
+	Unless   *RuleMatch `json:"unless"`
+	After    []string   `json:"after"`  // run after these modules
+	Before   []string   `json:"before"` // run before these modules
+	Args     []string   `json:"args"`
+	Deny     string     `json:"deny"`
+}
+
+// RuleMatch holds patterns (see matchPattern) that must all match for a
+// rule to apply.  An empty pattern matches anything.
+type RuleMatch struct {
+	Host          string            `json:"host"` // this Mesos agent
+	Registry      string            `json:"registry"`
+	Image         string            `json:"image"`
+	Tag           string            `json:"tag"`
+	Digest        string            `json:"digest"`
+	MarathonAppId string            `json:"marathon_app_id"`
+	MesosTaskId   string            `json:"mesos_task_id"`
+	Env           map[string]string `json:"env"`   // -e KEY => value pattern
+	Flags         map[string]string `json:"flags"` // run option long name => value pattern
+}
+
+// the loaded config, nil until loadWrapperConfig is called
+var wrapperConfig *WrapperConfig
+
+// loadWrapperConfig reads the config file and conf.d directory (or their env
+// overrides).  Config errors are logged, never fatal - we still want to exec
+// docker.
+func loadWrapperConfig() *WrapperConfig {
+	file := os.Getenv(ConfigFileEnv)
+	if file == "" {
+		file = DefaultConfigFile
+	}
+	dir := os.Getenv(ConfigDirEnv)
+	if dir == "" {
+		dir = DefaultConfigDir
+	}
+
+	config, err := readWrapperConfig(file, dir)
+	applyLoggingConfig(config.Logging)
+	applyRedactionConfig(config.Redaction)
+	if err != nil {
+		log.Printf("WARN: Error loading config: %v", err)
+	}
+	if isDebugEnabled() {
+		log.Printf("DEBUG: config = %+v", config.redacted())
+	}
+	wrapperConfig = config
+	return config
+}
+
+// readWrapperConfig merges the file and every *.json file in dir into a
+// single config.  Missing files are not an error, bad files are logged and
+// skipped so the rest still apply, and returned together as one error.
+func readWrapperConfig(file string, dir string) (*WrapperConfig, error) {
+	config := &WrapperConfig{}
+
+	files := []string{file}
+	dirFiles, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return config, err
+	}
+	sort.Strings(dirFiles)
+	files = append(files, dirFiles...)
+
+	problems := []string{}
+	for _, name := range files {
+		if err := config.mergeFile(name); err != nil {
+			log.Printf("WARN: skipping config file %s: %v", name, err)
+			problems = append(problems, name+": "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return config, fmt.Errorf("docker-wrapper: bad config files: %s", strings.Join(problems, "; "))
+	}
+	return config, nil
+}
+
+// mergeFile reads a single json config file and appends it to config
+func (config *WrapperConfig) mergeFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var part WrapperConfig
+	if err := json.Unmarshal(data, &part); err != nil {
+		return err
+	}
+	for i := range part.Rules {
+		if part.Rules[i].Name == "" {
+			part.Rules[i].Name = unnamedRuleName(name, i)
+		}
+	}
+	config.Rules = append(config.Rules, part.Rules...)
+	config.Mirrors = append(config.Mirrors, part.Mirrors...)
+	config.ImagePolicy.merge(part.ImagePolicy)
+	if part.PinDigests != nil {
+		config.PinDigests = part.PinDigests
+	}
+	if part.CpuQuota != nil {
+		config.CpuQuota = part.CpuQuota
+	}
+	if part.MemPolicy != nil {
+		config.MemPolicy = part.MemPolicy
+	}
+	if part.Limits != nil {
+		config.Limits = part.Limits
+	}
+	if part.Logging != nil {
+		config.Logging = part.Logging
+	}
+	for name, module := range part.Modules {
+		if config.Modules == nil {
+			config.Modules = map[string]ModuleConfig{}
+		}
+		config.Modules[name] = module
+	}
+	if part.ExecModules != nil {
+		config.ExecModules = part.ExecModules
+	}
+	if part.Redaction != nil {
+		if config.Redaction == nil {
+			config.Redaction = &RedactionConfig{}
+		}
+		config.Redaction.Keys = append(config.Redaction.Keys, part.Redaction.Keys...)
+		config.Redaction.Values = append(config.Redaction.Values, part.Redaction.Values...)
+	}
+	return nil
+}
+
+// unnamedRuleName names a rule without a name by its file and position (from
+// 1), e.g. rule-10-logging-2, so it can be ordered against and turned off
+func unnamedRuleName(file string, index int) string {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return fmt.Sprintf("rule-%s-%d", base, index+1)
+}
+
+// redacted copies the config for logging, with secrets in rule args redacted
+func (config *WrapperConfig) redacted() WrapperConfig {
+	copied := *config
+	copied.Rules = make([]ConfigRule, len(config.Rules))
+	for i, rule := range config.Rules {
+		copied.Rules[i] = rule
+		copied.Rules[i].Args = redactArgs(rule.Args)
+	}
+	return copied
+}
+
+// ********************
+
+// ConfigRunModule is the built-in module wrapping a single ConfigRule
+type ConfigRunModule struct {
+	DefaultRunModule
+	rule ConfigRule
+}
+
+// NewConfigRunModule creates a run module for rule, using the rule priority
+// and ordering
+func NewConfigRunModule(rule ConfigRule) *ConfigRunModule {
+	return &ConfigRunModule{
+		DefaultRunModule: DefaultRunModule{
+			Name:        rule.Name,
+			RunPriority: rule.Priority,
+			After:       rule.After,
+			Before:      rule.Before,
+		},
+		rule: rule,
+	}
+}
+
+// applies checks Match and Unless against the run
+func (m *ConfigRunModule) applies(ctx *runmodule.RunContext) bool {
+	if !m.rule.Match.matches(ctx) {
+		return false
+	}
+	return m.rule.Unless == nil || !m.rule.Unless.matches(ctx)
+}
+
+// deniesRuns is only true for rules with a deny reason, see runModuleDenies
+func (m *ConfigRunModule) deniesRuns() bool {
+	return m.rule.Deny != ""
+}
+
+// DenyRun implements the RunDenier interface
+func (m *ConfigRunModule) DenyRun(ctx *runmodule.RunContext) string {
+	if m.rule.Deny == "" || !m.applies(ctx) {
+		return ""
+	}
+	return m.rule.Deny
+}
+
+// HandleRun implements the RunModule interface
+func (m *ConfigRunModule) HandleRun(ctx *runmodule.RunContext) ([]string, error) {
+	if !m.applies(ctx) {
+		return nil, nil
+	}
+	if isDebugEnabled() {
+		log.Printf("DEBUG: config rule %q matched, args: %q", m.rule.Name, redactArgs(m.rule.Args))
+	}
+	return m.rule.Args, nil
+}
+
+// matches checks the host, image, mesos/marathon ids, -e values and run
+// options of the run
+func (match *RuleMatch) matches(ctx *runmodule.RunContext) bool {
+	if !matchPattern(match.Host, ctx.Host.Hostname) ||
+		!matchPattern(match.Registry, ctx.Image.Registry()) ||
+		!matchPattern(match.Image, ctx.Image.Repository()) ||
+		!matchPattern(match.Tag, ctx.Image.Tag) ||
+		!matchPattern(match.Digest, ctx.Image.Digest) ||
+		!matchPattern(match.MarathonAppId, ctx.MarathonAppId) ||
+		!matchPattern(match.MesosTaskId, ctx.MesosTaskId) {
+		return false
+	}
+	for key, pattern := range match.Env {
+		if !matchPattern(pattern, ctx.EnvValue(key)) {
+			return false
+		}
+	}
+	for name, pattern := range match.Flags {
+		if !matchAnyPattern(pattern, dockerflags.RunFlagValues(ctx.RunFlags, name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAnyPattern is true if any of the values match the pattern
+func matchAnyPattern(pattern string, values []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, value := range values {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// registerConfigRunModules registers the built-in modules for the config: a
+// ConfigRunModule for each config rule, the mirror, image policy, digest
+// pinning, resource limits, cpu quota and memory policy modules, and the
+// external modules
+func registerConfigRunModules(config *WrapperConfig) {
+	if config == nil {
+		return
+	}
+	for _, rule := range config.Rules {
+		RegisterModule(NewConfigRunModule(rule))
+	}
+	if len(config.Mirrors) > 0 {
+		RegisterModule(NewMirrorRunModule(config.Mirrors))
+	}
+	if !config.ImagePolicy.isEmpty() {
+		RegisterModule(NewImagePolicyRunModule(config.ImagePolicy))
+	}
+	if config.PinDigests != nil && config.PinDigests.Enabled {
+		RegisterModule(NewDigestPinRunModule(*config.PinDigests))
+	}
+	if config.Limits != nil {
+		RegisterModule(NewResourceLimitsRunModule(*config.Limits))
+	}
+	if config.CpuQuota != nil && config.CpuQuota.Enabled {
+		RegisterModule(NewCpuQuotaRunModule(*config.CpuQuota))
+	}
+	if config.MemPolicy != nil && config.MemPolicy.Enabled {
+		RegisterModule(NewMemoryPolicyRunModule(*config.MemPolicy))
+	}
+	registerExecRunModules(config.ExecModules)
+}
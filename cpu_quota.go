@@ -0,0 +1,107 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// hard CPU limits from the cpu-shares Mesos passes (1024 per CPU), which on
+// their own are only a relative weight
+
+import (
+	"log"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// the quota is worked out from the final image and shares
+const CpuQuotaModulePriority = -200
+
+const (
+	// cpu-shares docker gives a single CPU
+	SharesPerCpu = 1024
+
+	// default CFS period, in microseconds, as docker's own
+	DefaultCpuPeriod = 100000
+)
+
+// CpuQuotaConfig turns on CPU quotas.  The quota is the CPUs the shares stand
+// for times the BurstFactor (default 1, no burst), e.g. `-c 256` with a burst
+// factor of 2 can use half a CPU.
+type CpuQuotaConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Period      int64   `json:"period"` // cpu-period in microseconds
+	BurstFactor float64 `json:"burst_factor"`
+}
+
+// cpuQuota works out the quota for shares in each period, no less than the
+// smallest quota docker takes
+func (config *CpuQuotaConfig) cpuQuota(shares int64, period int64) int64 {
+	quota := int64(float64(shares) * float64(period) * config.BurstFactor / SharesPerCpu)
+	if quota < 1000 {
+		return 1000
+	}
+	return quota
+}
+
+// ********************
+
+// CpuQuotaRunModule adds --cpu-period and --cpu-quota to runs with
+// cpu-shares
+type CpuQuotaRunModule struct {
+	DefaultRunModule
+	config CpuQuotaConfig
+}
+
+// NewCpuQuotaRunModule creates the run module for the quota config
+func NewCpuQuotaRunModule(config CpuQuotaConfig) *CpuQuotaRunModule {
+	if config.Period == 0 {
+		config.Period = DefaultCpuPeriod
+	}
+	if config.BurstFactor <= 0 {
+		config.BurstFactor = 1
+	}
+	return &CpuQuotaRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "cpu-quota", RunPriority: CpuQuotaModulePriority},
+		config:           config,
+	}
+}
+
+// MutateRun implements the RunMutator interface, setting the period and
+// quota for the run's cpu-shares.  Runs without cpu-shares, or which already
+// set a quota (or --cpus), are left alone.  A cpu-period given with the run is kept and
+// the quota fitted to it.
+func (m *CpuQuotaRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	if _, set, _ := dockerflags.RunResource(ctx.RunFlags, "cpu-quota"); set {
+		return nil
+	}
+	// --cpus is a quota too, docker refuses it with --cpu-period
+	if ctx.RunFlags.Cpus != "" {
+		return nil
+	}
+	shares, set, err := dockerflags.RunResource(ctx.RunFlags, "cpu-shares")
+	if err != nil {
+		log.Printf("WARN: not setting a cpu quota: %v", err)
+		return nil
+	}
+	if !set {
+		return nil
+	}
+
+	mutations := []runmodule.RunMutation{}
+	period, set, err := dockerflags.RunResource(ctx.RunFlags, "cpu-period")
+	if err != nil {
+		log.Printf("WARN: not setting a cpu quota: %v", err)
+		return nil
+	}
+	if !set {
+		period = m.config.Period
+		mutations = append(mutations, runmodule.ReplaceResource("cpu-period", period))
+	}
+
+	quota := m.config.cpuQuota(shares, period)
+	if isDebugEnabled() {
+		log.Printf("DEBUG: cpu-shares %d => cpu-quota %d per %dus", shares, quota, period)
+	}
+	return append(mutations, runmodule.ReplaceResource("cpu-quota", quota))
+}
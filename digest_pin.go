@@ -0,0 +1,119 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// pin mutable image tags to the local repo digest at run time, so we know
+// exactly what a Mesos task ran even if the tag is later moved
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// pinning runs after the image policy has checked the tag
+const DigestPinModulePriority = -400
+
+// default label prefix for the original image and the pinned digest
+const DefaultDigestPinLabelPrefix = "com.yp.docker-wrapper"
+
+// DigestPinConfig turns on pinning, labels are <LabelPrefix>.image and
+// <LabelPrefix>.image-digest
+type DigestPinConfig struct {
+	Enabled     bool   `json:"enabled"`
+	LabelPrefix string `json:"label_prefix"`
+}
+
+// repoDigestFromInspect finds the repo digest for ref in `docker image inspect`
+// output of the image, as a repository@digest reference
+func repoDigestFromInspect(inspectJson string, ref imageref.ImageRef) (imageref.ImageRef, error) {
+	parsed, err := parseJsonFromString(inspectJson)
+	if err != nil {
+		return imageref.ImageRef{}, err
+	}
+	images, ok := parsed.([]interface{})
+	if !ok || len(images) == 0 {
+		return imageref.ImageRef{}, errors.New("docker-wrapper: no image in docker image inspect output")
+	}
+	image, ok := images[0].(map[string]interface{})
+	if !ok {
+		return imageref.ImageRef{}, errors.New("docker-wrapper: unexpected docker image inspect output")
+	}
+	repoDigests, _ := image["RepoDigests"].([]interface{})
+
+	repository := ref.Normalized().Repository()
+	for _, item := range repoDigests {
+		repoDigest, _ := item.(string)
+		digestRef, err := imageref.ParseImageRef(repoDigest)
+		if err != nil || digestRef.Digest == "" {
+			continue
+		}
+		if digestRef.Normalized().Repository() == repository {
+			pinned := ref
+			pinned.Tag = ""
+			pinned.Digest = digestRef.Digest
+			return pinned, nil
+		}
+	}
+	return imageref.ImageRef{}, fmt.Errorf("docker-wrapper: no repo digest for %q", ref.String())
+}
+
+// ********************
+
+// DigestPinRunModule rewrites image:tag to image@digest
+type DigestPinRunModule struct {
+	DefaultRunModule
+	config DigestPinConfig
+
+	// dockerImageInspect, on the daemon the run goes to
+	inspect func(dockerFlags dockerflags.DockerFlags, image string) (string, error)
+}
+
+// NewDigestPinRunModule creates the run module for the pinning config
+func NewDigestPinRunModule(config DigestPinConfig) *DigestPinRunModule {
+	if config.LabelPrefix == "" {
+		config.LabelPrefix = DefaultDigestPinLabelPrefix
+	}
+	return &DigestPinRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "digest-pin", RunPriority: DigestPinModulePriority},
+		config:           config,
+		inspect:          dockerImageInspect,
+	}
+}
+
+// MutateRun implements the RunMutator interface, replacing the image with
+// its pinned digest and labelling the container with both.  Images already
+// run by digest, or without a local repo digest (never pulled), are left
+// alone, as are runs with --pull=always: the local digest may be older than
+// what docker would pull.
+func (m *DigestPinRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	image := ctx.Image
+	if image.IsZero() || image.Digest != "" || ctx.RunFlags.Pull == "always" {
+		return nil
+	}
+
+	inspectJson, err := m.inspect(ctx.Flags, image.String())
+	if err != nil {
+		log.Printf("WARN: unable to inspect image %q for digest pinning: %v", image.String(), err)
+		return nil
+	}
+	pinned, err := repoDigestFromInspect(inspectJson, image)
+	if err != nil {
+		log.Printf("WARN: not pinning image: %v", err)
+		return nil
+	}
+
+	log.Printf("INFO: pinned image %q to %q", image.String(), pinned.String())
+	return []runmodule.RunMutation{
+		runmodule.ReplaceImage(pinned.String()),
+		runmodule.AddFlag("label",
+			m.config.LabelPrefix+".image="+image.String(),
+			m.config.LabelPrefix+".image-digest="+pinned.Digest),
+	}
+}
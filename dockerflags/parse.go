@@ -0,0 +1,295 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+// parsing docker args with go-flags https://github.com/jessevdk/go-flags.
+// Each parse gets a parser of its own, so results never leak between parses.
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// docker subcommands which are run equivalents, e.g. `docker create` and
+// `docker container run`
+var runCommandNames = []string{"run", "create"}
+
+// ParsedArgs is a parsed docker command line (without "docker" itself)
+type ParsedArgs struct {
+	// primary pre-command option flags
+	Flags DockerFlags
+
+	// docker run command flags, from whichever run equivalent command was
+	// parsed.  Left empty if the run options did not parse.
+	RunFlags DockerRunCommandFlags
+
+	// where each run option came from, by long name.  Options not given
+	// and without a default are left out.
+	RunFlagProvenance map[string]FlagProvenance
+
+	// docker pull command flags
+	PullFlags DockerPullCommandFlags
+
+	// the docker subcommand, e.g. "run" for `docker container run`, "" if
+	// there is none
+	Command string
+
+	// position of the subcommand in the args, -1 if none
+	CommandIndex int
+
+	// position of the docker pull image in the args, -1 if none
+	PullImageIndex int
+
+	// args left over by the parser
+	Remaining []string
+}
+
+// IsRun checks the command is docker run or one of its equivalents
+func (parsed *ParsedArgs) IsRun() bool {
+	return IsRunCommandName(parsed.Command)
+}
+
+// IsPull checks the command is docker pull
+func (parsed *ParsedArgs) IsPull() bool {
+	return parsed.Command == "pull"
+}
+
+// RunFlagSource is the provenance of a run option by its long name
+func (parsed *ParsedArgs) RunFlagSource(longName string) FlagProvenance {
+	return parsed.RunFlagProvenance[longName]
+}
+
+// Parse parses docker args.  Unknown options are ignored, except for run
+// options, and the args are always returned parsed as far as possible: the
+// error is only of interest for commands the caller cares about, docker
+// itself reports bad args.
+func Parse(args []string) (*ParsedArgs, error) {
+	parsed := &ParsedArgs{}
+	parser := newParser(parsed)
+
+	parseArgs := args
+	command, commandIndex := findCommand(parser.Parser, args)
+	if _, ok := parser.runFlags[command]; ok {
+		parseArgs = splitShortBoolGroups(command, args, commandIndex)
+	}
+
+	remaining, err := parser.ParseArgs(parseArgs)
+	parsed.Remaining = remaining
+	parsed.CommandIndex = commandIndex
+	parsed.PullImageIndex = -1
+
+	cmd := parser.Active
+	if cmd != nil && cmd.Name == "container" {
+		cmd = cmd.Active
+	}
+	if cmd != nil {
+		parsed.Command = cmd.Name
+		runFlags, ok := parser.runFlags[cmd]
+		if ok && err == nil && strings.HasPrefix(runFlags.Args.Image, "-") {
+			// an option the parser does not know, it would take the value
+			// (or the real image) as the image
+			err = fmt.Errorf("docker-wrapper: unknown run option %q", runFlags.Args.Image)
+		}
+		if ok && err == nil {
+			parsed.RunFlags = *runFlags
+			parsed.RunFlagProvenance = runFlagProvenance(cmd, args, parsed.CommandIndex)
+		}
+		if parsed.IsPull() {
+			parsed.PullImageIndex = findPositionalIndex(cmd, args, parsed.CommandIndex)
+		}
+	}
+	return parsed, err
+}
+
+// parser is a go-flags parser for a single parse
+type parser struct {
+	*flags.Parser
+
+	// each run equivalent command needs its own flags for the parser to
+	// track which options were set
+	runFlags map[*flags.Command]*DockerRunCommandFlags
+}
+
+// newParser sets up a parser filling in parsed.  It ignores unknown options
+// and passes all options after double dash --.  `docker create`, `docker
+// container run` and `docker container create` take the same options as
+// docker run.
+func newParser(parsed *ParsedArgs) *parser {
+	p := &parser{
+		Parser:   flags.NewParser(&parsed.Flags, flags.PassDoubleDash|flags.IgnoreUnknown|flags.PassAfterNonOption),
+		runFlags: map[*flags.Command]*DockerRunCommandFlags{},
+	}
+	p.addRunCommands(p.Command)
+
+	containerCmd, err := p.AddCommand("container",
+		"Manage containers",
+		"Usage: docker container COMMAND",
+		&struct{}{})
+	if err == nil {
+		p.addRunCommands(containerCmd)
+	}
+
+	p.AddCommand("pull",
+		"Pull an image or a repository from a registry",
+		"Usage: docker pull [OPTIONS] NAME[:TAG|@DIGEST]",
+		&parsed.PullFlags)
+	return p
+}
+
+// addRunCommands adds the run and create commands to a parser command
+func (p *parser) addRunCommands(cmd *flags.Command) {
+	runFlags, createFlags := &DockerRunCommandFlags{}, &DockerRunCommandFlags{}
+
+	if run, err := cmd.AddCommand("run",
+		"Run a command in a new container",
+		"Usage: docker run [OPTIONS] IMAGE [COMMAND] [ARG...]",
+		runFlags); err == nil {
+		p.runFlags[run] = runFlags
+	}
+	if create, err := cmd.AddCommand("create",
+		"Create a new container",
+		"Usage: docker create [OPTIONS] IMAGE [COMMAND] [ARG...]",
+		createFlags); err == nil {
+		p.runFlags[create] = createFlags
+	}
+}
+
+// findCommandIndex finds the position of the docker subcommand in args, e.g.
+// 1 for the "run" in `-D run -d img`, skipping docker's own options and their
+// values.  For management commands (`container run`) it is the position of
+// the final subcommand.  -1 if there is no subcommand.
+func findCommandIndex(parser *flags.Parser, args []string) int {
+	_, index := findCommand(parser, args)
+	return index
+}
+
+// findCommand finds the docker subcommand like findCommandIndex, along with
+// the parser command for it (nil for subcommands the parser does not know)
+func findCommand(parser *flags.Parser, args []string) (*flags.Command, int) {
+	cmd := parser.Command
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return nil, -1
+		}
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			if optionArgTakesValue(parser.Command, arg) {
+				i++
+			}
+			continue
+		}
+		sub := cmd.Find(arg)
+		if sub != nil && len(sub.Commands()) > 0 {
+			cmd = sub
+			continue
+		}
+		return sub, i
+	}
+	return nil, -1
+}
+
+// splitShortBoolGroups splits the grouped short options of the subcommand
+// cmd at commandIndex which start with a bool, e.g. -dit into -d -i -t and
+// -dm512m into -d -m512m.  The bools take an attached value (-d=false), so
+// the parser would take the rest of the group for the value of the first.
+func splitShortBoolGroups(cmd *flags.Command, args []string, commandIndex int) []string {
+	split := append([]string{}, args[:commandIndex+1]...)
+	for i := commandIndex + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || len(arg) == 1 {
+			return append(split, args[i:]...)
+		}
+
+		if !strings.HasPrefix(arg, "--") {
+			for len(arg) > 2 && arg[2] != '=' {
+				option := cmd.FindOptionByShortName(rune(arg[1]))
+				if option == nil || optionTakesValue(option) {
+					break
+				}
+				split = append(split, arg[:2])
+				arg = "-" + arg[2:]
+			}
+		}
+		split = append(split, arg)
+		if optionArgTakesValue(cmd, arg) && i+1 < len(args) {
+			i++
+			split = append(split, args[i])
+		}
+	}
+	return split
+}
+
+// findPositionalIndex finds the first positional arg of the subcommand cmd
+// at commandIndex (e.g. the image of `pull -q img`), skipping its options
+// and their values.  Options may follow it, as the parser allows.  -1 if
+// there is none.
+func findPositionalIndex(cmd *flags.Command, args []string, commandIndex int) int {
+	for i := commandIndex + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				return i + 1
+			}
+			return -1
+		}
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			if optionArgTakesValue(cmd, arg) {
+				i++
+			}
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// optionArgTakesValue checks if an option of cmd (e.g. "-H" or "--host" of
+// docker itself) takes its value from the next argument
+func optionArgTakesValue(cmd *flags.Command, arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		if strings.Contains(arg, "=") {
+			return false
+		}
+		return optionTakesValue(cmd.FindOptionByLongName(arg[2:]))
+	}
+
+	// short options can be grouped (-Dl debug) or have the value attached (-Hsock)
+	shorts := arg[1:]
+	for i, short := range shorts {
+		if optionTakesValue(cmd.FindOptionByShortName(short)) {
+			return i == len(shorts)-1
+		}
+	}
+	return false
+}
+
+// optionTakesValue is true for known non-bool options which need a value
+// (--sig-proxy only takes one attached)
+func optionTakesValue(option *flags.Option) bool {
+	return option != nil && !option.OptionalArgument && option.Field().Type.Kind() != reflect.Bool
+}
+
+// IsRunCommandName checks for a single run equivalent word
+func IsRunCommandName(arg string) bool {
+	for _, name := range runCommandNames {
+		if arg == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RunCommandIndex finds the "run" or "create" subcommand argument, -1 if the
+// subcommand is something else
+func RunCommandIndex(args []string) int {
+	index := findCommandIndex(newParser(&ParsedArgs{}).Parser, args)
+	if index == -1 || !IsRunCommandName(args[index]) {
+		return -1
+	}
+	return index
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindCommandIndex(t *testing.T) {
+	commandIndex := func(args ...string) int {
+		parsed, _ := Parse(args)
+		return parsed.CommandIndex
+	}
+	assert.Equal(t, 0, commandIndex("run", "img", "sh", "-c", "run"))
+	assert.Equal(t, 2, commandIndex("-H", "unix:///var/run/docker.sock", "run", "img"))
+	assert.Equal(t, 1, commandIndex("--host=tcp://run:2375", "run", "img"))
+	assert.Equal(t, 3, commandIndex("-Dl", "debug", "--tls", "pull", "run"))
+	assert.Equal(t, 1, commandIndex("-Hunix:///var/run/docker.sock", "run", "img"))
+	assert.Equal(t, 2, commandIndex("-D", "container", "create", "img"))
+	assert.Equal(t, -1, commandIndex("--version"))
+}
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse([]string{"-D", "container", "run", "-e", "A=1", "--cpu-shares", "256", "centos:7", "sh", "-c", "true"})
+	assert.NoError(t, err)
+	assert.True(t, parsed.Flags.Debug)
+	assert.Equal(t, "run", parsed.Command)
+	assert.True(t, parsed.IsRun())
+	assert.Equal(t, []string{"A=1"}, parsed.RunFlags.Env)
+	assert.Equal(t, "256", parsed.RunFlags.CpuShares)
+	assert.Equal(t, "centos:7", parsed.RunFlags.Args.Image)
+	assert.Equal(t, []string{"sh", "-c", "true"}, parsed.RunFlags.Args.CmdArgs)
+
+	// nothing carries over from the last parse
+	parsed, err = Parse([]string{"create", "busybox"})
+	assert.NoError(t, err)
+	assert.False(t, parsed.Flags.Debug)
+	assert.Equal(t, "create", parsed.Command)
+	assert.Empty(t, parsed.RunFlags.Env)
+	assert.Equal(t, "busybox", parsed.RunFlags.Args.Image)
+
+	parsed, err = Parse([]string{"pull", "-q", "centos"})
+	assert.NoError(t, err)
+	assert.True(t, parsed.IsPull())
+	assert.False(t, parsed.IsRun())
+	assert.True(t, parsed.PullFlags.Quiet)
+	assert.Equal(t, "centos", parsed.PullFlags.Args.Image)
+	assert.Equal(t, 2, parsed.PullImageIndex)
+
+	pullImageIndex := func(args ...string) int {
+		parsed, _ := Parse(args)
+		return parsed.PullImageIndex
+	}
+	assert.Equal(t, 1, pullImageIndex("pull", "img", "-q"))
+	assert.Equal(t, 3, pullImageIndex("-H", "unix:///var/run/docker.sock", "pull", "img", "--platform", "linux/amd64"))
+	assert.Equal(t, 3, pullImageIndex("pull", "--platform", "linux/amd64", "img"))
+	assert.Equal(t, 2, pullImageIndex("pull", "--platform=linux/amd64", "img"))
+	assert.Equal(t, -1, pullImageIndex("run", "img"))
+
+	// a run without an image is still a run, without run flags
+	parsed, err = Parse([]string{"run", "--rm"})
+	assert.Error(t, err)
+	assert.True(t, parsed.IsRun())
+	assert.False(t, bool(parsed.RunFlags.Rm))
+
+	// an unknown run option is not taken for the image
+	parsed, err = Parse([]string{"run", "--privileged", "--frobnicate", "2", "nginx:1"})
+	assert.Error(t, err)
+	assert.True(t, parsed.IsRun())
+	assert.False(t, bool(parsed.RunFlags.Privileged))
+	assert.Equal(t, "", parsed.RunFlags.Args.Image)
+
+	parsed, _ = Parse([]string{"ps", "-a"})
+	assert.Equal(t, "", parsed.Command)
+	assert.Equal(t, 0, parsed.CommandIndex)
+}
+
+func TestParse_modern(t *testing.T) {
+	args := []string{"run", "--init", "--network=host", "--cpus", "1.5", "--gpus", "all",
+		"--mount", "type=bind,source=/a,target=/a", "--platform", "linux/amd64", "--pull", "always",
+		"--health-cmd", "curl -f http://localhost/", "--health-interval=30s", "--health-retries", "3",
+		"--health-start-period", "10s", "--health-timeout", "5s", "--privileged", "nginx:1", "nginx", "-g", "daemon off;"}
+	parsed, err := Parse(args)
+	assert.NoError(t, err)
+	assert.True(t, bool(parsed.RunFlags.Init))
+	assert.Equal(t, "host", parsed.RunFlags.Network)
+	assert.Equal(t, "1.5", parsed.RunFlags.Cpus)
+	assert.Equal(t, "all", parsed.RunFlags.Gpus)
+	assert.Equal(t, []string{"type=bind,source=/a,target=/a"}, parsed.RunFlags.Mount)
+	assert.Equal(t, "linux/amd64", parsed.RunFlags.Platform)
+	assert.Equal(t, "always", parsed.RunFlags.Pull)
+	assert.Equal(t, "curl -f http://localhost/", parsed.RunFlags.HealthCmd)
+	assert.Equal(t, "30s", parsed.RunFlags.HealthInterval)
+	assert.Equal(t, "3", parsed.RunFlags.HealthRetries)
+	assert.Equal(t, "10s", parsed.RunFlags.HealthStartPeriod)
+	assert.Equal(t, "5s", parsed.RunFlags.HealthTimeout)
+	assert.True(t, bool(parsed.RunFlags.Privileged))
+	assert.Equal(t, "nginx:1", parsed.RunFlags.Args.Image)
+	assert.Equal(t, []string{"nginx", "-g", "daemon off;"}, parsed.RunFlags.Args.CmdArgs)
+
+	parsed, err = Parse([]string{"create", "--no-healthcheck", "nginx:1"})
+	assert.NoError(t, err)
+	assert.True(t, bool(parsed.RunFlags.NoHealthcheck))
+	assert.Equal(t, "nginx:1", parsed.RunFlags.Args.Image)
+}
+
+func TestParse_bools(t *testing.T) {
+	// docker takes explicit values for its bools
+	args := []string{"run", "--rm=false", "--sig-proxy=false", "-d=false", "-i=false", "--privileged=true", "--init", "-t", "img"}
+	parsed, err := Parse(args)
+	assert.NoError(t, err)
+	assert.False(t, bool(parsed.RunFlags.Rm))
+	assert.Equal(t, "false", parsed.RunFlags.SigProxy)
+	assert.False(t, bool(parsed.RunFlags.Detach))
+	assert.False(t, bool(parsed.RunFlags.Interactive))
+	assert.True(t, bool(parsed.RunFlags.Privileged))
+	assert.True(t, bool(parsed.RunFlags.Init))
+	assert.True(t, bool(parsed.RunFlags.Tty))
+	assert.Equal(t, "img", parsed.RunFlags.Args.Image)
+	assert.Equal(t, FlagProvenance{Source: FlagExplicit, Positions: []int{3}}, parsed.RunFlagSource("detach"))
+
+	// the value is only taken attached
+	parsed, err = Parse([]string{"run", "--rm", "false", "--sig-proxy", "img"})
+	assert.NoError(t, err)
+	assert.True(t, bool(parsed.RunFlags.Rm))
+	assert.Equal(t, "false", parsed.RunFlags.Args.Image)
+	assert.Equal(t, []string{"--sig-proxy", "img"}, parsed.RunFlags.Args.CmdArgs)
+
+	// grouped short options still parse, the CMD args are left alone
+	parsed, err = Parse([]string{"container", "run", "-dit", "-Pm512m", "-it=false", "img", "sh", "-dit"})
+	assert.NoError(t, err)
+	assert.True(t, bool(parsed.RunFlags.Detach))
+	assert.True(t, bool(parsed.RunFlags.PublishAll))
+	assert.Equal(t, "512m", parsed.RunFlags.Memory)
+	assert.True(t, bool(parsed.RunFlags.Interactive))
+	assert.False(t, bool(parsed.RunFlags.Tty))
+	assert.Equal(t, []string{"sh", "-dit"}, parsed.RunFlags.Args.CmdArgs)
+	assert.Equal(t, []int{2, 4}, parsed.RunFlagSource("tty").Positions)
+
+	// a value docker would not take is still an error
+	_, err = Parse([]string{"run", "--rm=maybe", "img"})
+	assert.Error(t, err)
+}
+
+func TestParse_provenance(t *testing.T) {
+	args := []string{"-D", "run", "-dit", "-m512m", "--net", "host", "--name=x", "-e", "A=1", "--env", "B=2", "img", "--restart", "always"}
+	parsed, err := Parse(args)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]FlagProvenance{
+		"detach":      {Source: FlagExplicit, Positions: []int{2}},
+		"interactive": {Source: FlagExplicit, Positions: []int{2}},
+		"tty":         {Source: FlagExplicit, Positions: []int{2}},
+		"memory":      {Source: FlagExplicit, Positions: []int{3}},
+		"net":         {Source: FlagExplicit, Positions: []int{4}},
+		"name":        {Source: FlagExplicit, Positions: []int{6}},
+		"env":         {Source: FlagExplicit, Positions: []int{7, 9}},
+		// after the image is the CMD
+		"restart":   {Source: FlagDefault},
+		"sig-proxy": {Source: FlagDefault},
+	}, parsed.RunFlagProvenance)
+	assert.Equal(t, FlagUnset, parsed.RunFlagSource("privileged").Source)
+
+	assert.Equal(t, "explicit, args 7 9", parsed.RunFlagSource("env").String())
+	assert.Equal(t, "default", parsed.RunFlagSource("restart").String())
+	assert.Equal(t, `set by "labels", was arg 4`, FlagProvenance{Source: FlagModule, Module: "labels", Positions: []int{4}}.String())
+
+	// an explicit value equal to the default is still explicit
+	parsed, _ = Parse([]string{"container", "create", "--net=bridge", "img"})
+	assert.Equal(t, FlagProvenance{Source: FlagExplicit, Positions: []int{2}}, parsed.RunFlagSource("net"))
+
+	parsed, _ = Parse([]string{"pull", "img"})
+	assert.Nil(t, parsed.RunFlagProvenance)
+}
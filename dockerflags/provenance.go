@@ -0,0 +1,152 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+// where the value of each run option came from: the command line, a parser
+// default or a run module
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// FlagSource is where the value of a run option came from
+type FlagSource int
+
+const (
+	FlagUnset    FlagSource = iota // not given, no default
+	FlagDefault                    // not given, filled in by the parser
+	FlagExplicit                   // given on the command line
+	FlagModule                     // changed by a run module
+)
+
+var flagSourceNames = []string{"unset", "default", "explicit", "module"}
+
+func (source FlagSource) String() string {
+	if int(source) < len(flagSourceNames) {
+		return flagSourceNames[source]
+	}
+	return fmt.Sprintf("unknown source %d", int(source))
+}
+
+// MarshalText encodes the source by name, e.g. for exec modules
+func (source FlagSource) MarshalText() ([]byte, error) {
+	return []byte(source.String()), nil
+}
+
+// UnmarshalText decodes a source name
+func (source *FlagSource) UnmarshalText(text []byte) error {
+	for i, name := range flagSourceNames {
+		if string(text) == name {
+			*source = FlagSource(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("docker-wrapper: unknown flag source %q", text)
+}
+
+// FlagProvenance is where the value of a run option came from.  Positions
+// are the indexes in the parsed args where the option was given, kept when
+// a module changes it.
+type FlagProvenance struct {
+	Source    FlagSource `json:"source"`
+	Module    string     `json:"module,omitempty"` // for FlagModule
+	Positions []int      `json:"positions,omitempty"`
+}
+
+// String describes the provenance for the explain output, e.g. "explicit,
+// arg 2" or `set by "labels", was arg 2`
+func (provenance FlagProvenance) String() string {
+	positions := ""
+	if len(provenance.Positions) > 0 {
+		words := []string{}
+		for _, position := range provenance.Positions {
+			words = append(words, fmt.Sprint(position))
+		}
+		if len(words) == 1 {
+			positions = "arg " + words[0]
+		} else {
+			positions = "args " + strings.Join(words, " ")
+		}
+	}
+
+	switch provenance.Source {
+	case FlagExplicit:
+		return "explicit, " + positions
+	case FlagModule:
+		if positions != "" {
+			return fmt.Sprintf("set by %q, was %s", provenance.Module, positions)
+		}
+		return fmt.Sprintf("set by %q", provenance.Module)
+	}
+	return provenance.Source.String()
+}
+
+// runFlagProvenance works out the provenance of every given or defaulted
+// option of a parsed run command, by long name
+func runFlagProvenance(cmd *flags.Command, args []string, commandIndex int) map[string]FlagProvenance {
+	provenance := map[string]FlagProvenance{}
+	for name, positions := range runFlagPositions(cmd, args, commandIndex) {
+		provenance[name] = FlagProvenance{Source: FlagExplicit, Positions: positions}
+	}
+
+	t := reflect.TypeOf(DockerRunCommandFlags{})
+	for i := 0; i < t.NumField(); i++ {
+		long := t.Field(i).Tag.Get("long")
+		if _, given := provenance[long]; !given && long != "" && t.Field(i).Tag.Get("default") != "" {
+			provenance[long] = FlagProvenance{Source: FlagDefault}
+		}
+	}
+	return provenance
+}
+
+// runFlagPositions finds where each run option was given, from after the
+// command up to the image, the same way the parser reads them
+func runFlagPositions(cmd *flags.Command, args []string, commandIndex int) map[string][]int {
+	positions := map[string][]int{}
+	for i := commandIndex + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || len(arg) == 1 {
+			break
+		}
+
+		if strings.HasPrefix(arg, "--") {
+			name, hasValue := arg[2:], false
+			if eq := strings.Index(name, "="); eq != -1 {
+				name, hasValue = name[:eq], true
+			}
+			option := cmd.FindOptionByLongName(name)
+			if option == nil {
+				// the parser takes it for the image
+				break
+			}
+			positions[option.LongName] = append(positions[option.LongName], i)
+			if !hasValue && optionTakesValue(option) {
+				i++
+			}
+			continue
+		}
+
+		// short options can be grouped (-dit) or have the value attached (-m512m)
+		shorts := arg[1:]
+		for j, short := range shorts {
+			option := cmd.FindOptionByShortName(short)
+			if option == nil {
+				break
+			}
+			positions[option.LongName] = append(positions[option.LongName], i)
+			if optionTakesValue(option) {
+				if j == len(shorts)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return positions
+}
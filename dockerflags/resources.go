@@ -0,0 +1,299 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+// typed access to the resource limit run options (memory, CPU, pids, shm),
+// which the parser leaves as the strings docker was given
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resourceOption describes how a resource run option is read and which
+// values docker accepts
+type resourceOption struct {
+	size     bool  // in bytes, with docker size units
+	min, max int64 // max 0 for no maximum
+	minusOne bool  // -1 is allowed too (unlimited, or the docker default)
+}
+
+// the resource run options by long name
+var resourceOptions = map[string]resourceOption{
+	"memory":             {size: true, min: 4 * 1024 * 1024},
+	"memory-swap":        {size: true, min: 0, minusOne: true},
+	"memory-reservation": {size: true, min: 0},
+	"kernel-memory":      {size: true, min: 4 * 1024 * 1024},
+	"shm-size":           {size: true, min: 1},
+	"memory-swappiness":  {min: 0, max: 100, minusOne: true},
+	"cpu-shares":         {min: 2, max: 262144},
+	"cpu-period":         {min: 1000, max: 1000000},
+	"cpu-quota":          {min: 1000, minusOne: true},
+	"blkio-weight":       {min: 0, max: 1000},
+	"oom-score-adj":      {min: -1000, max: 1000},
+	"pids-limit":         {min: 0, minusOne: true},
+}
+
+// what docker uses when the option is not given
+const (
+	DefaultCpuShares = 1024
+	DefaultShmSize   = 64 * 1024 * 1024
+)
+
+// docker size units, binary like docker's own parsing
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"t", 1 << 40},
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+	{"", 1},
+}
+
+// IsResourceOption checks a run option long name is one of the numeric
+// resource limits, e.g. "memory" or "cpu-shares"
+func IsResourceOption(longName string) bool {
+	_, ok := resourceOptions[longName]
+	return ok
+}
+
+// ResourceOptions lists the resource run option long names in name order
+func ResourceOptions() []string {
+	names := []string{}
+	for name := range resourceOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSize parses a docker size, e.g. "512m", "1.5g" or "1024" (bytes).
+// Units are b, k, m, g and t in either case, optionally followed by "b"
+// ("512mb"), all multiples of 1024.
+func ParseSize(value string) (int64, error) {
+	number := strings.ToLower(strings.TrimSpace(value))
+	number = strings.TrimSuffix(number, "b")
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if unit.suffix != "" && strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.multiplier
+			break
+		}
+	}
+
+	if whole, err := strconv.ParseInt(number, 10, 64); err == nil {
+		if whole < 0 {
+			return 0, fmt.Errorf("docker-wrapper: negative size %q", value)
+		}
+		return whole * multiplier, nil
+	}
+	// only plain decimals, no exponents, infinities or signs
+	fraction, err := strconv.ParseFloat(number, 64)
+	if err != nil || strings.Trim(number, "0123456789.") != "" {
+		return 0, fmt.Errorf("docker-wrapper: invalid size %q", value)
+	}
+	return int64(fraction * float64(multiplier)), nil
+}
+
+// FormatSize writes bytes as a docker size in the largest unit it is a whole
+// number of, e.g. "512m"
+func FormatSize(bytes int64) string {
+	for _, unit := range sizeUnits {
+		if bytes != 0 && bytes%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", bytes/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprint(bytes)
+}
+
+// ParseResource parses the value of a resource run option: sizes in bytes,
+// everything else as a plain integer.  Values docker would refuse are an
+// error.
+func ParseResource(longName, value string) (int64, error) {
+	option, ok := resourceOptions[longName]
+	if !ok {
+		return 0, fmt.Errorf("docker-wrapper: %q is not a resource run option", longName)
+	}
+
+	var number int64
+	var err error
+	if option.size && strings.TrimSpace(value) != "-1" {
+		number, err = ParseSize(value)
+	} else {
+		number, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("docker-wrapper: invalid --%s %q", longName, value)
+	}
+	if number == -1 && option.minusOne {
+		return number, nil
+	}
+	if number < option.min || (option.max != 0 && number > option.max) {
+		return 0, fmt.Errorf("docker-wrapper: --%s %q is out of range", longName, value)
+	}
+	return number, nil
+}
+
+// FormatResource writes a resource run option value, sizes with units
+func FormatResource(longName string, value int64) string {
+	if resourceOptions[longName].size && value > 0 {
+		return FormatSize(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// RunResource reads a resource run option by its long name, in bytes for
+// sizes.  set is false if the option was not given (or is empty).
+func RunResource(runFlags DockerRunCommandFlags, longName string) (value int64, set bool, err error) {
+	if !IsResourceOption(longName) {
+		return 0, false, fmt.Errorf("docker-wrapper: %q is not a resource run option", longName)
+	}
+	field, _ := RunFlagField(reflect.ValueOf(runFlags), longName)
+	if field.String() == "" {
+		return 0, false, nil
+	}
+	value, err = ParseResource(longName, field.String())
+	return value, err == nil, err
+}
+
+// SetRunResource sets a resource run option by its long name, in bytes for
+// sizes.  Values docker would refuse are an error and leave runFlags as is.
+func SetRunResource(runFlags *DockerRunCommandFlags, longName string, value int64) error {
+	formatted := FormatResource(longName, value)
+	if _, err := ParseResource(longName, formatted); err != nil {
+		return err
+	}
+	field, _ := RunFlagField(reflect.ValueOf(runFlags).Elem(), longName)
+	field.SetString(formatted)
+	return nil
+}
+
+// ValidateResources checks every resource run option given has a value
+// docker accepts, and that the memory options agree with each other
+func ValidateResources(runFlags DockerRunCommandFlags) error {
+	values := map[string]int64{}
+	for _, name := range ResourceOptions() {
+		value, set, err := RunResource(runFlags, name)
+		if err != nil {
+			return err
+		}
+		if set {
+			values[name] = value
+		}
+	}
+
+	memory, hasMemory := values["memory"]
+	if swap, ok := values["memory-swap"]; ok && swap != -1 {
+		if !hasMemory {
+			return fmt.Errorf("docker-wrapper: --memory-swap needs --memory")
+		}
+		if swap < memory {
+			return fmt.Errorf("docker-wrapper: --memory-swap %s is less than --memory %s", FormatSize(swap), FormatSize(memory))
+		}
+	}
+	if reservation, ok := values["memory-reservation"]; ok && hasMemory && reservation > memory {
+		return fmt.Errorf("docker-wrapper: --memory-reservation %s is more than --memory %s", FormatSize(reservation), FormatSize(memory))
+	}
+	return nil
+}
+
+// ParseCPUSet parses a cpuset (--cpuset-cpus or --cpuset-mems), e.g.
+// "0-3,8", into the sorted numbers it covers
+func ParseCPUSet(value string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		last := first
+		if err == nil && len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || first < 0 || last < first {
+			return nil, fmt.Errorf("docker-wrapper: invalid cpuset %q", value)
+		}
+		for n := first; n <= last; n++ {
+			seen[n] = true
+		}
+	}
+
+	numbers := []int{}
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// FormatCPUSet writes sorted numbers as a cpuset, runs as ranges ("0-3,8")
+func FormatCPUSet(numbers []int) string {
+	parts := []string{}
+	for i := 0; i < len(numbers); i++ {
+		first := numbers[i]
+		for i+1 < len(numbers) && numbers[i+1] == numbers[i]+1 {
+			i++
+		}
+		if numbers[i] == first {
+			parts = append(parts, fmt.Sprint(first))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", first, numbers[i]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Ulimit is a parsed --ulimit value, e.g. "nofile=1024:2048".  Hard is the
+// same as Soft if only one is given, -1 is unlimited.
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// ParseUlimit parses a --ulimit value, name=soft[:hard]
+func ParseUlimit(value string) (Ulimit, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Ulimit{}, fmt.Errorf("docker-wrapper: invalid ulimit %q", value)
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := strconv.ParseInt(limits[0], 10, 64)
+	hard := soft
+	if err == nil && len(limits) == 2 {
+		hard, err = strconv.ParseInt(limits[1], 10, 64)
+	}
+	if err != nil || soft < -1 || hard < -1 {
+		return Ulimit{}, fmt.Errorf("docker-wrapper: invalid ulimit %q", value)
+	}
+	return Ulimit{Name: parts[0], Soft: soft, Hard: hard}, nil
+}
+
+// String writes the ulimit as a --ulimit value
+func (ulimit Ulimit) String() string {
+	if ulimit.Soft == ulimit.Hard {
+		return fmt.Sprintf("%s=%d", ulimit.Name, ulimit.Soft)
+	}
+	return fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard)
+}
+
+// RunUlimit finds the last --ulimit of a run for name (e.g. "nofile"), which
+// is the one docker uses
+func RunUlimit(runFlags DockerRunCommandFlags, name string) (ulimit Ulimit, set bool, err error) {
+	for _, value := range runFlags.Ulimit {
+		parsed, parseErr := ParseUlimit(value)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		if parsed.Name == name {
+			ulimit, set = parsed, true
+		}
+	}
+	return ulimit, set, err
+}
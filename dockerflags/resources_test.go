@@ -0,0 +1,119 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"1024":  1024,
+		"100b":  100,
+		"4k":    4096,
+		"512m":  512 * 1024 * 1024,
+		"512MB": 512 * 1024 * 1024,
+		"1.5g":  1536 * 1024 * 1024,
+		"2G":    2 * 1024 * 1024 * 1024,
+		"1t":    1024 * 1024 * 1024 * 1024,
+	} {
+		size, err := ParseSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+	for _, value := range []string{"", "m", "-1m", "1x", "1e3", "inf", "1..5g"} {
+		_, err := ParseSize(value)
+		assert.Error(t, err, value)
+	}
+
+	assert.Equal(t, "512m", FormatSize(512*1024*1024))
+	assert.Equal(t, "1536m", FormatSize(1536*1024*1024))
+	assert.Equal(t, "2g", FormatSize(2*1024*1024*1024))
+	assert.Equal(t, "1000", FormatSize(1000))
+	assert.Equal(t, "0", FormatSize(0))
+}
+
+func TestRunResource(t *testing.T) {
+	parsed, err := Parse([]string{"run", "-m", "1g", "--memory-swap=-1", "-c", "512", "--pids-limit", "100", "--shm-size", "64m", "img"})
+	assert.NoError(t, err)
+
+	value, set, err := RunResource(parsed.RunFlags, "memory")
+	assert.Equal(t, []interface{}{int64(1024 * 1024 * 1024), true, nil}, []interface{}{value, set, err})
+	value, _, _ = RunResource(parsed.RunFlags, "memory-swap")
+	assert.Equal(t, int64(-1), value)
+	value, _, _ = RunResource(parsed.RunFlags, "cpu-shares")
+	assert.Equal(t, int64(512), value)
+	value, _, _ = RunResource(parsed.RunFlags, "shm-size")
+	assert.Equal(t, int64(64*1024*1024), value)
+	_, set, err = RunResource(parsed.RunFlags, "cpu-quota")
+	assert.False(t, set)
+	assert.NoError(t, err)
+	_, _, err = RunResource(parsed.RunFlags, "name")
+	assert.Error(t, err)
+	assert.NoError(t, ValidateResources(parsed.RunFlags))
+
+	runFlags := parsed.RunFlags
+	assert.NoError(t, SetRunResource(&runFlags, "memory", 768*1024*1024))
+	assert.NoError(t, SetRunResource(&runFlags, "cpu-quota", 50000))
+	assert.Equal(t, "768m", runFlags.Memory)
+	assert.Equal(t, "50000", runFlags.CpuQuota)
+	assert.Error(t, SetRunResource(&runFlags, "oom-score-adj", 2000))
+	assert.Error(t, SetRunResource(&runFlags, "cpu-shares", 1))
+	assert.Equal(t, "", runFlags.OomScoreAdj)
+	assert.Equal(t, "512", runFlags.CpuShares)
+
+	// out of range values and memory options which disagree
+	for _, args := range [][]string{
+		{"run", "--oom-score-adj=-1001", "img"},
+		{"run", "--cpu-period", "100", "img"},
+		{"run", "--memory-swappiness", "101", "img"},
+		{"run", "-m", "1m", "img"},
+		{"run", "-m", "lots", "img"},
+		{"run", "-m", "1g", "--memory-swap", "512m", "img"},
+		{"run", "--memory-swap", "2g", "img"},
+		{"run", "-m", "1g", "--memory-reservation", "2g", "img"},
+	} {
+		parsed, _ := Parse(args)
+		assert.Error(t, ValidateResources(parsed.RunFlags), "%q", args)
+	}
+}
+
+func TestParseCPUSet(t *testing.T) {
+	cpus, err := ParseCPUSet("0-3,8, 2,10-11")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 8, 10, 11}, cpus)
+	assert.Equal(t, "0-3,8,10-11", FormatCPUSet(cpus))
+	assert.Equal(t, "5", FormatCPUSet([]int{5}))
+
+	for _, value := range []string{"", "a", "3-1", "-1", "1,"} {
+		_, err := ParseCPUSet(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseUlimit(t *testing.T) {
+	ulimit, err := ParseUlimit("nofile=1024:2048")
+	assert.NoError(t, err)
+	assert.Equal(t, Ulimit{Name: "nofile", Soft: 1024, Hard: 2048}, ulimit)
+	assert.Equal(t, "nofile=1024:2048", ulimit.String())
+
+	ulimit, err = ParseUlimit("nproc=-1")
+	assert.NoError(t, err)
+	assert.Equal(t, Ulimit{Name: "nproc", Soft: -1, Hard: -1}, ulimit)
+	assert.Equal(t, "nproc=-1", ulimit.String())
+
+	for _, value := range []string{"", "nofile", "=1", "nofile=a", "nofile=1:b", "nofile=-2"} {
+		_, err := ParseUlimit(value)
+		assert.Error(t, err, value)
+	}
+
+	parsed, _ := Parse([]string{"run", "--ulimit", "nofile=1024", "--ulimit", "nproc=10", "--ulimit", "nofile=4096:8192", "img"})
+	ulimit, set, err := RunUlimit(parsed.RunFlags, "nofile")
+	assert.Equal(t, []interface{}{Ulimit{Name: "nofile", Soft: 4096, Hard: 8192}, true, nil}, []interface{}{ulimit, set, err})
+	_, set, _ = RunUlimit(parsed.RunFlags, "core")
+	assert.False(t, set)
+}
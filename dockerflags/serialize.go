@@ -0,0 +1,145 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+// writing parsed run options back out, and splicing args into docker
+// command lines
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// SerializeRunFlags renders runFlags back into docker run arguments (without
+// the "run" itself), in a canonical form: every option as --long=value (bools
+// as --long) in struct order, list options in the order given, then the image
+// and the CMD args as they are.  Options at their default value are left out.
+// Parsing the result gives runFlags back, as long as CanSerializeRunFlags.
+//
+// The CMD args need no quoting: the image ends the docker options, so the
+// args after it are passed on as separate arguments however they look.
+func SerializeRunFlags(runFlags DockerRunCommandFlags) []string {
+	args := []string{}
+
+	v := reflect.ValueOf(runFlags)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		long := t.Field(i).Tag.Get("long")
+		if long == "" {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Bool:
+			if field.Bool() {
+				args = append(args, "--"+long)
+			}
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				args = append(args, fmt.Sprintf("--%s=%v", long, field.Index(j).Interface()))
+			}
+		default:
+			// a value set to "" where there is a default must be kept, the
+			// parser would fill in the default
+			value := fmt.Sprint(field.Interface())
+			def, hasDefault := t.Field(i).Tag.Lookup("default")
+			if !hasDefault {
+				def = fmt.Sprint(reflect.Zero(field.Type()).Interface())
+			}
+			if value != def {
+				args = append(args, "--"+long+"="+value)
+			}
+		}
+	}
+
+	args = append(args, runFlags.Args.Image)
+	args = append(args, runFlags.Args.CmdArgs...)
+	return args
+}
+
+// ConnectionArgs renders the docker options which pick the daemon and the
+// client config (config, context, host and TLS), so other docker calls reach
+// the same daemon with the same credentials as the command line
+func ConnectionArgs(dockerFlags DockerFlags) []string {
+	args := []string{}
+	if dockerFlags.Config != "" {
+		args = append(args, "--config="+dockerFlags.Config)
+	}
+	if dockerFlags.Context != "" {
+		args = append(args, "--context="+dockerFlags.Context)
+	}
+	for _, host := range dockerFlags.Host {
+		args = append(args, "--host="+host)
+	}
+	if dockerFlags.Tls {
+		args = append(args, "--tls")
+	}
+	for _, option := range []struct {
+		long  string
+		value flags.Filename
+	}{{"tlscacert", dockerFlags.TlsCaCert}, {"tlscert", dockerFlags.TlsCert}, {"tlskey", dockerFlags.TlsKey}} {
+		if option.value != "" {
+			args = append(args, fmt.Sprintf("--%s=%s", option.long, option.value))
+		}
+	}
+	if dockerFlags.TlsVerify {
+		args = append(args, "--tlsverify")
+	}
+	return args
+}
+
+// CanSerializeRunFlags checks the parse was clean enough to re-serialize.
+// Parse leaves the run flags empty when the run options did not parse (e.g.
+// an unknown option), so there is no image.
+func CanSerializeRunFlags(runFlags DockerRunCommandFlags) bool {
+	return runFlags.Args.Image != "" && !strings.HasPrefix(runFlags.Args.Image, "-")
+}
+
+// ReplaceRunArgs keeps the docker args up to and including "run" (or
+// create) and replaces everything after with runArgs
+func ReplaceRunArgs(args []string, runArgs []string) []string {
+	runIndex := RunCommandIndex(args)
+	if runIndex == -1 {
+		// not docker run? leave the args alone
+		return args
+	}
+
+	newArgs := append([]string{}, args[0:runIndex+1]...)
+	return append(newArgs, runArgs...)
+}
+
+// InjectRunArgs takes the current argument list and another argument list to
+// inject after the "docker run" (or create) portions of the command arguments
+func InjectRunArgs(args []string, injectArgs []string) []string {
+	if len(injectArgs) == 0 {
+		return args
+	}
+
+	// find the run command argument index
+	runIndex := RunCommandIndex(args)
+	if runIndex == -1 {
+		// something funny - not docker run? just append all args
+		return args
+	}
+
+	// split the difference and merge in new args after "run"
+	newArgs := append([]string{}, args[0:runIndex+1]...)
+	newArgs = append(newArgs, injectArgs...)
+	return append(newArgs, args[runIndex+1:]...)
+}
+
+// ReplacePullImage swaps the image of docker pull, at imageIndex in args
+// (see ParsedArgs.PullImageIndex)
+func ReplacePullImage(args []string, imageIndex int, image string) []string {
+	if imageIndex < 0 || imageIndex >= len(args) {
+		return args
+	}
+	newArgs := append([]string{}, args...)
+	newArgs[imageIndex] = image
+	return newArgs
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCorpus is a set of docker run command lines, as Mesos, Marathon and
+// Chronos call docker
+type runCorpus []struct {
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+}
+
+func readRunCorpus(t *testing.T) runCorpus {
+	data, err := ioutil.ReadFile("testdata/run_corpus.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus runCorpus
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		t.Fatal(err)
+	}
+	return corpus
+}
+
+func TestSerializeRunFlags_roundTrip(t *testing.T) {
+	for _, example := range readRunCorpus(t) {
+		parsed, err := Parse(example.Args)
+		if !assert.NoError(t, err, example.Name) || !assert.True(t, parsed.IsRun(), example.Name) {
+			continue
+		}
+		assert.True(t, CanSerializeRunFlags(parsed.RunFlags), example.Name)
+
+		serialized := SerializeRunFlags(parsed.RunFlags)
+		reparsed, err := Parse(append([]string{"run"}, serialized...))
+		assert.NoError(t, err, example.Name)
+		assert.Equal(t, parsed.RunFlags, reparsed.RunFlags, example.Name)
+
+		// canonical: serializing again changes nothing
+		assert.Equal(t, serialized, SerializeRunFlags(reparsed.RunFlags), example.Name)
+	}
+}
+
+func TestSerializeRunFlags(t *testing.T) {
+	// option order and short or long names do not matter
+	first, _ := Parse([]string{"run", "-v", "/a:/a", "-e", "A=1", "-d", "--name", "x", "img", "sh", "-c", "echo a  b"})
+	second, _ := Parse([]string{"run", "--name=x", "--detach", "--env=A=1", "--volume", "/a:/a", "img", "sh", "-c", "echo a  b"})
+	expected := []string{"--detach", "--env=A=1", "--name=x", "--volume=/a:/a", "img", "sh", "-c", "echo a  b"}
+	assert.Equal(t, expected, SerializeRunFlags(first.RunFlags))
+	assert.Equal(t, expected, SerializeRunFlags(second.RunFlags))
+
+	// defaults are left out, an empty value instead of a default is kept
+	parsed, _ := Parse([]string{"run", "--net", "bridge", "--restart=", "img"})
+	assert.Equal(t, []string{"--restart=", "img"}, SerializeRunFlags(parsed.RunFlags))
+
+	// bools given false are left out, except --sig-proxy which defaults to true
+	parsed, _ = Parse([]string{"run", "--rm=false", "--sig-proxy=false", "img"})
+	assert.Equal(t, []string{"--sig-proxy=false", "img"}, SerializeRunFlags(parsed.RunFlags))
+
+	// an unknown option is an error, leaving nothing to serialize
+	parsed, err := Parse([]string{"run", "--not-an-option", "value", "img"})
+	assert.Error(t, err)
+	assert.False(t, CanSerializeRunFlags(parsed.RunFlags))
+}
+
+func TestConnectionArgs(t *testing.T) {
+	parsed, _ := Parse([]string{"-D", "--config", "/etc/ci", "-H", "tcp://a:2376", "--host=tcp://b:2376", "--tlsverify",
+		"--tlscacert=/ca.pem", "--tlscert", "/cert.pem", "--tlskey=/key.pem", "run", "-c", "512", "img"})
+	assert.Equal(t, []string{"--config=/etc/ci", "--host=tcp://a:2376", "--host=tcp://b:2376",
+		"--tlscacert=/ca.pem", "--tlscert=/cert.pem", "--tlskey=/key.pem", "--tlsverify"}, ConnectionArgs(parsed.Flags))
+	assert.Equal(t, "512", parsed.RunFlags.CpuShares)
+
+	parsed, _ = Parse([]string{"-c", "remote", "--tls", "pull", "img"})
+	assert.Equal(t, 3, parsed.CommandIndex)
+	assert.Equal(t, []string{"--context=remote", "--tls"}, ConnectionArgs(parsed.Flags))
+
+	parsed, _ = Parse([]string{"ps"})
+	assert.Empty(t, ConnectionArgs(parsed.Flags))
+}
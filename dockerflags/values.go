@@ -0,0 +1,142 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dockerflags
+
+// reading parsed option values by their docker option names
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EnvValuesLike looks for env vars like string and returns list of values
+func EnvValuesLike(env []string, like string) []string {
+	var splitVal []string
+	var results []string = []string{}
+	for _, envItem := range env {
+		if strings.HasPrefix(envItem, like) {
+			splitVal = strings.SplitN(envItem, "=", 2)
+			if splitVal != nil && len(splitVal) > 1 {
+				results = append(results, splitVal[1])
+			}
+		}
+	}
+	return results
+}
+
+// EnvValueLike uses EnvValuesLike and returns just the first match value
+func EnvValueLike(env []string, like string) string {
+	possibles := EnvValuesLike(env, like)
+	if possibles != nil && len(possibles) > 0 {
+		return possibles[0]
+	}
+	return ""
+}
+
+// RunFlagValues returns the value(s) of a run option by its long name (e.g.
+// "privileged", "volume"), as strings.  Unset options return no values.
+func RunFlagValues(runFlags DockerRunCommandFlags, longName string) []string {
+	field, ok := RunFlagField(reflect.ValueOf(runFlags), longName)
+	if !ok {
+		return nil
+	}
+	return flagFieldValues(field)
+}
+
+// OptionValues maps the long name of every set option of a flags struct to
+// its value(s) as strings
+func OptionValues(flagsStruct interface{}) map[string][]string {
+	values := map[string][]string{}
+	v := reflect.ValueOf(flagsStruct)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		long := t.Field(i).Tag.Get("long")
+		if long == "" {
+			continue
+		}
+		if fieldValues := flagFieldValues(v.Field(i)); len(fieldValues) > 0 {
+			values[long] = fieldValues
+		}
+	}
+	return values
+}
+
+// RunFlagField finds the struct field for a run option long name in a
+// (reflected) DockerRunCommandFlags
+func RunFlagField(v reflect.Value, longName string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if longName != "" && t.Field(i).Tag.Get("long") == longName {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// RunFlagDefault is the default value of a run option by its long name, ""
+// if it has none
+func RunFlagDefault(longName string) string {
+	t := reflect.TypeOf(DockerRunCommandFlags{})
+	for i := 0; i < t.NumField(); i++ {
+		if longName != "" && t.Field(i).Tag.Get("long") == longName {
+			return t.Field(i).Tag.Get("default")
+		}
+	}
+	return ""
+}
+
+// flagFieldValues returns the value(s) of an option field as strings
+func flagFieldValues(field reflect.Value) []string {
+	switch field.Kind() {
+	case reflect.Bool:
+		if field.Bool() {
+			return []string{"true"}
+		}
+	case reflect.String:
+		if field.String() != "" {
+			return []string{field.String()}
+		}
+	case reflect.Slice:
+		values := []string{}
+		for j := 0; j < field.Len(); j++ {
+			values = append(values, fmt.Sprint(field.Index(j).Interface()))
+		}
+		return values
+	}
+	return nil
+}
+
+// CopyFlags copies docker's own flags, so the copy can be changed without
+// touching the original
+func CopyFlags(dockerFlags DockerFlags) DockerFlags {
+	copyListOptions(reflect.ValueOf(&dockerFlags).Elem())
+	return dockerFlags
+}
+
+// CopyRunFlags copies run flags, including the list options and CMD args,
+// so the copy can be changed without touching the original
+func CopyRunFlags(runFlags DockerRunCommandFlags) DockerRunCommandFlags {
+	copyListOptions(reflect.ValueOf(&runFlags).Elem())
+	return runFlags
+}
+
+// copyListOptions gives every slice field of a (reflected, settable) flags
+// struct, and of the structs in it, a copy of its own
+func copyListOptions(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			copyListOptions(field)
+		case reflect.Slice:
+			if !field.IsNil() {
+				copied := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+				reflect.Copy(copied, field)
+				field.Set(copied)
+			}
+		}
+	}
+}
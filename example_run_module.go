@@ -8,28 +8,30 @@ package main
 import (
 	"fmt"
 	"log"
+
+	"github.com/yp-engineering/docker-wrapper/runmodule"
 )
 
 type ExampleRunModule struct {
 	DefaultRunModule
 }
 
-// HandleRun implements the WrapperRunModule interface
-func (m *ExampleRunModule) HandleRun(flags DockerFlags, runFlags DockerRunCommandFlags) []string {
+// HandleRun implements the RunModule interface
+func (m *ExampleRunModule) HandleRun(ctx *runmodule.RunContext) ([]string, error) {
 	log.Println("INFO: ExampleRunModule.HandleRun(...)")
 
 	// look for a few 'standard' vars and craft our own
-	// (run_cmd.go already looks for MESOS_TASK_ID and pals)
+	// (the RunContext already has MESOS_TASK_ID and pals)
 
-	ports := singleEnvValueLike(runFlags.Env, "PORTS")
+	ports := ctx.EnvValue("PORTS")
 
 	// we are combining a few pieces of data into a new env var flag
-	newflags := []string{"-e", fmt.Sprintf("EXAMPLE_RUN_MODULE=%s-%s", mesosTaskId, ports)}
+	newflags := []string{"-e", fmt.Sprintf("EXAMPLE_RUN_MODULE=%s-%s", ctx.MesosTaskId, ports)}
 
-	return newflags
+	return newflags, nil
 }
 
-// init calls RegisterRunModule
+// init calls RegisterModule
 func init() {
-	RegisterRunModule(&ExampleRunModule{})
+	RegisterModule(&ExampleRunModule{})
 }
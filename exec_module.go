@@ -0,0 +1,310 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// external run modules - executables in modules.d which get the parsed run
+// as JSON on stdin and answer with JSON on stdout, so modules can be written
+// in any language and shipped without rebuilding the wrapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	// executables in the directory are run as modules, in Priority order
+	// taken from a numeric name prefix (e.g. 50-logging), then by name
+	DefaultModuleDir = "/etc/docker-wrapper/modules.d"
+	ModuleDirEnv     = "DOCKER_WRAPPER_MODULE_DIR"
+
+	DefaultExecModuleTimeout = 5 * time.Second
+
+	// how long to wait for the output after killing a timed out module
+	execModuleWaitDelay = time.Second
+
+	// sent in the request, bumped on incompatible changes
+	ExecModuleProtocolVersion = 1
+)
+
+// ExecModuleConfig configures the external modules, all optional
+type ExecModuleConfig struct {
+	Dir       string `json:"dir"`
+	Timeout   string `json:"timeout"`    // per module, e.g. "2s"
+	OnFailure string `json:"on_failure"` // allow (default) or deny, see FailurePolicy
+}
+
+// ExecModuleRequest is written to the module's stdin.  Options are keyed by
+// their long name (e.g. "memory" for -m) and only set options are included.
+type ExecModuleRequest struct {
+	Version       int                 `json:"version"`
+	Module        string              `json:"module"`
+	DockerFlags   map[string][]string `json:"docker_flags"`
+	RunFlags      map[string][]string `json:"run_flags"`
+	Image         imageref.ImageRef   `json:"image"`
+	CmdArgs       []string            `json:"cmd_args"`
+	MesosTaskId   string              `json:"mesos_task_id"`
+	MarathonAppId string              `json:"marathon_app_id"`
+	Framework     string              `json:"framework"`
+	Hostname      string              `json:"hostname"`
+
+	// the run is only being explained (or is a dry run), a module should
+	// not act on it outside the wrapper
+	DryRun bool `json:"dry_run"`
+
+	// where each run option came from, see dockerflags.FlagProvenance
+	RunFlagProvenance map[string]dockerflags.FlagProvenance `json:"run_flag_provenance"`
+}
+
+// ExecModuleResponse is read from the module's stdout, every field is
+// optional and empty output means nothing to do
+type ExecModuleResponse struct {
+	Deny    string              `json:"deny"`    // reason to refuse the run
+	Args    []string            `json:"args"`    // args to inject after "run"
+	Remove  []string            `json:"remove"`  // run options to remove
+	Replace map[string][]string `json:"replace"` // run options to replace
+	Image   string              `json:"image"`   // image to run instead
+}
+
+// mutations turns the response into run mutations, replaced options in name
+// order
+func (response *ExecModuleResponse) mutations() []runmodule.RunMutation {
+	mutations := []runmodule.RunMutation{}
+	for _, flag := range response.Remove {
+		mutations = append(mutations, runmodule.RemoveFlag(flag))
+	}
+	flags := []string{}
+	for flag := range response.Replace {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		mutations = append(mutations, runmodule.ReplaceFlag(flag, response.Replace[flag]...))
+	}
+	if response.Image != "" {
+		mutations = append(mutations, runmodule.ReplaceImage(response.Image))
+	}
+	return mutations
+}
+
+// ********************
+
+// ExecRunModule runs a single external module executable.  A module which
+// exits non-zero, answers garbage or times out has failed, see FailurePolicy.
+type ExecRunModule struct {
+	DefaultRunModule
+	path    string
+	timeout time.Duration
+
+	// the answer for the current run, see DenyRun
+	response *ExecModuleResponse
+	err      error
+}
+
+// NewExecRunModule creates the run module for an executable, the priority
+// comes from a numeric prefix of the file name
+func NewExecRunModule(path string, timeout time.Duration, policy runmodule.FailurePolicy) *ExecRunModule {
+	name := filepath.Base(path)
+	return &ExecRunModule{
+		DefaultRunModule: DefaultRunModule{
+			Name:        name,
+			RunPriority: execModulePriority(name),
+			// leave time for the executable to be killed at its own timeout
+			RunTimeout: timeout + 2*execModuleWaitDelay,
+			OnFailure:  policy,
+		},
+		path:    path,
+		timeout: timeout,
+	}
+}
+
+// execModulePriority parses the number before the first '-' of a module
+// file name, 0 if there is none
+func execModulePriority(name string) int {
+	prefix := strings.SplitN(name, "-", 2)[0]
+	priority, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0
+	}
+	return priority
+}
+
+// DenyRun implements the RunDenier interface.  It is the first call for
+// each run, so the module executable is run here and its answer (or failure)
+// kept for MutateRun and HandleRun.
+func (m *ExecRunModule) DenyRun(ctx *runmodule.RunContext) string {
+	m.response, m.err = m.exec(ctx)
+	if m.err != nil {
+		return ""
+	}
+	return m.response.Deny
+}
+
+// MutateRun implements the RunMutator interface
+func (m *ExecRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	if response := m.currentResponse(ctx); response != nil {
+		return response.mutations()
+	}
+	return nil
+}
+
+// HandleRun implements the RunModule interface, reporting a failed module
+func (m *ExecRunModule) HandleRun(ctx *runmodule.RunContext) ([]string, error) {
+	if response := m.currentResponse(ctx); response != nil {
+		return response.Args, nil
+	}
+	return nil, m.err
+}
+
+// currentResponse is the answer from DenyRun, running the module if needed.
+// A failed module answers nothing.
+func (m *ExecRunModule) currentResponse(ctx *runmodule.RunContext) *ExecModuleResponse {
+	if m.response == nil && m.err == nil {
+		m.DenyRun(ctx)
+	}
+	return m.response
+}
+
+// exec runs the module executable with the request on stdin, killing it
+// and anything it started after the timeout, or when the wrapper stops
+// waiting for the module if that is sooner
+func (m *ExecRunModule) exec(ctx *runmodule.RunContext) (*ExecModuleResponse, error) {
+	request, err := json.Marshal(ExecModuleRequest{
+		Version:       ExecModuleProtocolVersion,
+		Module:        m.Name,
+		DockerFlags:   dockerflags.OptionValues(ctx.Flags),
+		RunFlags:      dockerflags.OptionValues(ctx.RunFlags),
+		Image:         ctx.Image,
+		CmdArgs:       ctx.RunFlags.Args.CmdArgs,
+		MesosTaskId:   ctx.MesosTaskId,
+		MarathonAppId: ctx.MarathonAppId,
+		Framework:     ctx.Framework,
+		Hostname:      ctx.Host.Hostname,
+		DryRun:        ctx.DryRun,
+
+		RunFlagProvenance: ctx.Provenance,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	if !ctx.Deadline.IsZero() && ctx.Deadline.Before(deadline) {
+		deadline = ctx.Deadline
+	}
+	limit := time.Until(deadline).Round(time.Millisecond)
+	timeout, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	cmd := exec.CommandContext(timeout, m.path)
+	// on timeout kill the module and anything it started, which could keep
+	// its stdout open
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = execModuleWaitDelay
+	cmd.Stdin = bytes.NewReader(request)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if isDebugEnabled() {
+		log.Printf("DEBUG: running external module %q", m.path)
+	}
+	err = cmd.Run()
+	if stderr.Len() > 0 {
+		log.Printf("INFO: external module %q stderr: %s", m.Name, logRedactor.redactValue(strings.TrimSpace(stderr.String())))
+	}
+	if timeout.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("docker-wrapper: external module timed out after %v", limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ExecModuleResponse{}
+	if output := bytes.TrimSpace(stdout.Bytes()); len(output) > 0 {
+		if err := json.Unmarshal(output, response); err != nil {
+			return nil, fmt.Errorf("docker-wrapper: bad external module response: %v", err)
+		}
+	}
+	return response, nil
+}
+
+// ********************
+
+// execModulePaths lists the module executables in dir by name.  Hidden
+// files, directories and files anyone but the owner can write are skipped.
+func execModulePaths(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("WARN: unable to read module dir: %v", err)
+		}
+		return nil
+	}
+
+	paths := []string{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") || !entry.Mode().IsRegular() || entry.Mode()&0111 == 0 {
+			continue
+		}
+		if entry.Mode()&0022 != 0 {
+			log.Printf("WARN: skipping external module %q, it is group or world writable", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// registerExecRunModules registers a module for each executable in the
+// module dir (or its env override)
+func registerExecRunModules(config *ExecModuleConfig) {
+	if config == nil {
+		config = &ExecModuleConfig{}
+	}
+
+	dir := os.Getenv(ModuleDirEnv)
+	if dir == "" {
+		dir = config.Dir
+	}
+	if dir == "" {
+		dir = DefaultModuleDir
+	}
+
+	timeout := DefaultExecModuleTimeout
+	if config.Timeout != "" {
+		parsed, err := time.ParseDuration(config.Timeout)
+		if err != nil || parsed <= 0 {
+			log.Printf("WARN: bad external module timeout %q, using %v", config.Timeout, timeout)
+		} else {
+			timeout = parsed
+		}
+	}
+
+	policy, err := parseFailurePolicy(config.OnFailure)
+	if err != nil {
+		log.Printf("WARN: %v for external modules", err)
+	}
+
+	for _, path := range execModulePaths(dir) {
+		RegisterModule(NewExecRunModule(path, timeout, policy))
+	}
+}
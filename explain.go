@@ -0,0 +1,100 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// dry run / explain mode: show what the modules would do to a docker command
+// instead of calling docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	// docker-wrapper explain -- <docker args>
+	ExplainCommand = "explain"
+
+	// DOCKER_WRAPPER_DRY_RUN=1 explains any docker command
+	DryRunEnv = "DOCKER_WRAPPER_DRY_RUN"
+)
+
+// isDryRunEnabled checks for the DOCKER_WRAPPER_DRY_RUN env var
+func isDryRunEnabled() bool {
+	return os.Getenv(DryRunEnv) == "1"
+}
+
+// explainDockerArgs strips "explain" and an optional "--" from the args
+func explainDockerArgs(args []string) []string {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	return args
+}
+
+// printExplain writes the original args, each module's contribution in
+// priority order, where the run options came from and the final args.  ctx
+// is nil if the command is not a run.
+func printExplain(w io.Writer, ctx *runmodule.RunContext, original []string, results []moduleResult, final []string) {
+	fmt.Fprintf(w, "original: docker %q\n", original)
+
+	if len(results) > 0 {
+		fmt.Fprintln(w, "modules:")
+	}
+	for _, result := range results {
+		fmt.Fprintf(w, "  [%d] %s:", result.Priority, result.Name)
+		if result.Skipped != "" {
+			fmt.Fprintf(w, " skipped, %s\n", result.Skipped)
+			continue
+		}
+		if result.Denied != "" {
+			fmt.Fprintf(w, " DENY %q\n", result.Denied)
+			continue
+		}
+		if result.Error != "" {
+			fmt.Fprintf(w, " FAILED %q, skipped\n", result.Error)
+			continue
+		}
+		if len(result.Mutations) == 0 && len(result.Args) == 0 {
+			fmt.Fprint(w, " no changes")
+		}
+		for _, mutation := range result.Mutations {
+			fmt.Fprintf(w, " %s;", mutation)
+		}
+		if len(result.Args) > 0 {
+			fmt.Fprintf(w, " inject %q", result.Args)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if denial := deniedResult(results); denial != nil {
+		fmt.Fprintf(w, "denied: %s\n", denial.Denied)
+		return
+	}
+	printExplainProvenance(w, ctx)
+	fmt.Fprintf(w, "final: docker %q\n", final)
+}
+
+// printExplainProvenance writes where each run option came from, in name
+// order.  Values are left out, they are in the final args.
+func printExplainProvenance(w io.Writer, ctx *runmodule.RunContext) {
+	if ctx == nil || len(ctx.Provenance) == 0 {
+		return
+	}
+	names := []string{}
+	for name := range ctx.Provenance {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "flags:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  --%s: %s\n", name, ctx.Provenance[name])
+	}
+}
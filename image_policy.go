@@ -0,0 +1,113 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// image allowlist/denylist by registry, repository and tag
+
+import (
+	"fmt"
+
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// image policy runs after the mirrors have rewritten the image
+const ImagePolicyModulePriority = -500
+
+// ImagePolicy refuses to run images that match no Allow pattern (when there
+// are any) or that match a Deny pattern.  Marathon apps matching ExemptApps
+// are not checked.
+type ImagePolicy struct {
+	Allow      []ImagePattern `json:"allow"`
+	Deny       []ImagePattern `json:"deny"`
+	ExemptApps []string       `json:"exempt_apps"`
+}
+
+// ImagePattern matches the normalized image (see matchPattern), e.g. "centos"
+// is registry "docker.io", repository "library/centos" and tag "latest"
+type ImagePattern struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Reason     string `json:"reason"`
+}
+
+// merge appends another policy's patterns and exemptions
+func (policy *ImagePolicy) merge(other ImagePolicy) {
+	policy.Allow = append(policy.Allow, other.Allow...)
+	policy.Deny = append(policy.Deny, other.Deny...)
+	policy.ExemptApps = append(policy.ExemptApps, other.ExemptApps...)
+}
+
+// isEmpty is true when there is nothing to check
+func (policy *ImagePolicy) isEmpty() bool {
+	return len(policy.Allow) == 0 && len(policy.Deny) == 0
+}
+
+func (pattern ImagePattern) matches(ref imageref.ImageRef) bool {
+	return matchPattern(pattern.Registry, ref.Registry()) &&
+		matchPattern(pattern.Repository, ref.Path) &&
+		matchPattern(pattern.Tag, ref.Tag)
+}
+
+// check returns the reason to deny image, "" if it is allowed
+func (policy *ImagePolicy) check(image string, appId string) string {
+	for _, exempt := range policy.ExemptApps {
+		if appId != "" && matchPattern(exempt, appId) {
+			return ""
+		}
+	}
+
+	ref, err := imageref.ParseImageRef(image)
+	if err != nil {
+		return fmt.Sprintf("invalid image %q", image)
+	}
+	ref = ref.Normalized()
+
+	if len(policy.Allow) > 0 {
+		allowed := false
+		for _, pattern := range policy.Allow {
+			if pattern.matches(ref) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("image %q is not from an approved registry or repository", image)
+		}
+	}
+
+	for _, pattern := range policy.Deny {
+		if pattern.matches(ref) {
+			if pattern.Reason != "" {
+				return fmt.Sprintf("image %q denied: %s", image, pattern.Reason)
+			}
+			return fmt.Sprintf("image %q denied by image policy", image)
+		}
+	}
+	return ""
+}
+
+// ********************
+
+// ImagePolicyRunModule enforces the ImagePolicy on docker run
+type ImagePolicyRunModule struct {
+	DefaultRunModule
+	policy ImagePolicy
+}
+
+// NewImagePolicyRunModule creates the run module for the configured policy
+func NewImagePolicyRunModule(policy ImagePolicy) *ImagePolicyRunModule {
+	return &ImagePolicyRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "image-policy", RunPriority: ImagePolicyModulePriority, NoOptOut: true},
+		policy:           policy,
+	}
+}
+
+// DenyRun implements the RunDenier interface, checking the image as it will
+// be run, after any mirror rewrite
+func (m *ImagePolicyRunModule) DenyRun(ctx *runmodule.RunContext) string {
+	return m.policy.check(ctx.RunFlags.Args.Image, ctx.MarathonAppId)
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package imageref parses docker image references into their registry, path, tag and digest.
+package imageref
+
+// docker image reference parsing, e.g.
+//   centos:centos6.6
+//   registry.local:5000/team/app:1.0
+//   app@sha256:0123...
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// implicit registry and namespace for images like "centos"
+	DefaultRegistry  = "docker.io"
+	DefaultNamespace = "library"
+	DefaultTag       = "latest"
+)
+
+var (
+	// grammar follows github.com/docker/distribution/reference
+	imageDomainRegexp    = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*$`)
+	imagePortRegexp      = regexp.MustCompile(`^[0-9]+$`)
+	imageComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	imageTagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	imageDigestRegexp    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// ImageRef is a parsed docker image reference.  Domain and Port are empty
+// when the reference has no registry, see Normalized.
+type ImageRef struct {
+	Domain string `json:"domain,omitempty"` // registry host name
+	Port   string `json:"port,omitempty"`   // registry port
+	Path   string `json:"path"`             // repository path, e.g. "team/app" or "centos"
+	Tag    string `json:"tag,omitempty"`
+	Digest string `json:"digest,omitempty"` // e.g. "sha256:0123..."
+}
+
+// ParseImageRef parses an image reference as given to docker run or pull
+func ParseImageRef(s string) (ImageRef, error) {
+	var ref ImageRef
+	if s == "" {
+		return ref, fmt.Errorf("docker-wrapper: empty image reference")
+	}
+	remaining := s
+
+	// digest comes last, after '@'
+	if i := strings.Index(remaining, "@"); i != -1 {
+		ref.Digest = remaining[i+1:]
+		remaining = remaining[:i]
+		if !imageDigestRegexp.MatchString(ref.Digest) {
+			return ImageRef{}, fmt.Errorf("docker-wrapper: invalid digest in image reference %q", s)
+		}
+	}
+
+	// a tag follows the last ':' only if there is no '/' after it (otherwise
+	// it is a registry port)
+	if i := strings.LastIndex(remaining, ":"); i != -1 && !strings.Contains(remaining[i+1:], "/") {
+		ref.Tag = remaining[i+1:]
+		remaining = remaining[:i]
+		if !imageTagRegexp.MatchString(ref.Tag) {
+			return ImageRef{}, fmt.Errorf("docker-wrapper: invalid tag in image reference %q", s)
+		}
+	}
+
+	// the first component is a registry if it looks like a host name
+	if i := strings.Index(remaining, "/"); i != -1 {
+		first := remaining[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Domain = first
+			if j := strings.Index(first, ":"); j != -1 {
+				ref.Domain, ref.Port = first[:j], first[j+1:]
+				if !imagePortRegexp.MatchString(ref.Port) {
+					return ImageRef{}, fmt.Errorf("docker-wrapper: invalid registry port in image reference %q", s)
+				}
+			}
+			if !imageDomainRegexp.MatchString(ref.Domain) {
+				return ImageRef{}, fmt.Errorf("docker-wrapper: invalid registry in image reference %q", s)
+			}
+			remaining = remaining[i+1:]
+		}
+	}
+
+	ref.Path = remaining
+	for _, component := range strings.Split(ref.Path, "/") {
+		if !imageComponentRegexp.MatchString(component) {
+			return ImageRef{}, fmt.Errorf("docker-wrapper: invalid repository name in image reference %q", s)
+		}
+	}
+	return ref, nil
+}
+
+// Registry is the registry host[:port], "" if not given
+func (ref ImageRef) Registry() string {
+	if ref.Port != "" {
+		return ref.Domain + ":" + ref.Port
+	}
+	return ref.Domain
+}
+
+// Repository is the image name as given, without tag or digest, e.g. "centos"
+// or "registry.local:5000/team/app"
+func (ref ImageRef) Repository() string {
+	if ref.Domain == "" {
+		return ref.Path
+	}
+	return ref.Registry() + "/" + ref.Path
+}
+
+// String renders the reference back to the form docker accepts
+func (ref ImageRef) String() string {
+	s := ref.Repository()
+	if ref.Tag != "" {
+		s += ":" + ref.Tag
+	}
+	if ref.Digest != "" {
+		s += "@" + ref.Digest
+	}
+	return s
+}
+
+// Normalized fills in the implicit docker.io registry, library/ namespace
+// and latest tag, e.g. "centos" => "docker.io/library/centos:latest"
+func (ref ImageRef) Normalized() ImageRef {
+	if ref.Domain == "" {
+		ref.Domain = DefaultRegistry
+	}
+	if ref.Registry() == DefaultRegistry && !strings.Contains(ref.Path, "/") {
+		ref.Path = DefaultNamespace + "/" + ref.Path
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref
+}
+
+// IsZero is true for an empty (unparsed) reference
+func (ref ImageRef) IsZero() bool {
+	return ref.Path == ""
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package imageref
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImageRef(t *testing.T) {
+	digest := "sha256:2ea7ca5b0cfcd0f4b32c9b4ca4a0aa6b3cb4e4ef1b07fa5da1c49a5a3aa0f3d4"
+	tests := []struct {
+		full       string
+		expected   ImageRef
+		registry   string
+		repository string
+		normalized string
+	}{
+		{"centos", ImageRef{Path: "centos"},
+			"", "centos", "docker.io/library/centos:latest"},
+		{"centos:centos6.6", ImageRef{Path: "centos", Tag: "centos6.6"},
+			"", "centos", "docker.io/library/centos:centos6.6"},
+		{"jess/nsqexec", ImageRef{Path: "jess/nsqexec"},
+			"", "jess/nsqexec", "docker.io/jess/nsqexec:latest"},
+		{"docker.io/centos", ImageRef{Domain: "docker.io", Path: "centos"},
+			"docker.io", "docker.io/centos", "docker.io/library/centos:latest"},
+		{"registry.local:5000/team/app", ImageRef{Domain: "registry.local", Port: "5000", Path: "team/app"},
+			"registry.local:5000", "registry.local:5000/team/app", "registry.local:5000/team/app:latest"},
+		{"registry.local:5000/team/app:1.0", ImageRef{Domain: "registry.local", Port: "5000", Path: "team/app", Tag: "1.0"},
+			"registry.local:5000", "registry.local:5000/team/app", "registry.local:5000/team/app:1.0"},
+		{"localhost/app", ImageRef{Domain: "localhost", Path: "app"},
+			"localhost", "localhost/app", "localhost/app:latest"},
+		{"app@" + digest, ImageRef{Path: "app", Digest: digest},
+			"", "app", "docker.io/library/app@" + digest},
+		{"old-registry.yp.com/app:1.0@" + digest, ImageRef{Domain: "old-registry.yp.com", Path: "app", Tag: "1.0", Digest: digest},
+			"old-registry.yp.com", "old-registry.yp.com/app", "old-registry.yp.com/app:1.0@" + digest},
+	}
+	for _, test := range tests {
+		ref, err := ParseImageRef(test.full)
+		assert.NoError(t, err, test.full)
+		assert.Equal(t, test.expected, ref, test.full)
+		assert.Equal(t, test.registry, ref.Registry(), test.full)
+		assert.Equal(t, test.repository, ref.Repository(), test.full)
+		assert.Equal(t, test.full, ref.String(), test.full)
+		assert.Equal(t, test.normalized, ref.Normalized().String(), test.full)
+	}
+
+	for _, bad := range []string{"", "--bogus", "Centos", "app:", "app@sha256:xyz", "registry:port/app", "app:bad/tag:x"} {
+		_, err := ParseImageRef(bad)
+		assert.Error(t, err, "expected error for %q", bad)
+	}
+}
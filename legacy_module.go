@@ -0,0 +1,155 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// the original run module interfaces, taking the parsed flags and reading
+// the image and mesos/marathon ids from package globals.  They keep working
+// through an adapter to the RunContext interfaces.
+
+import (
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// Module interface for docker wrapper Run modules, before RunContext
+//   - Priority()  - a way to set order of operation - sorted in ascending order for execution
+//   - HandleRun(...) - handle any run-command context, setting global vars as needed and return new docker run args to inject
+
+type WrapperRunModule interface {
+	Priority() int
+	HandleRun(dockerflags.DockerFlags, dockerflags.DockerRunCommandFlags) []string
+}
+
+// Optional interface for WrapperRunModules which enforce a policy, see RunDenier
+
+type WrapperRunDenier interface {
+	DenyRun(dockerflags.DockerFlags, dockerflags.DockerRunCommandFlags) string
+}
+
+// Optional interface for WrapperRunModules which change the existing run
+// arguments, see RunMutator
+
+type WrapperRunMutator interface {
+	MutateRun(dockerflags.DockerFlags, dockerflags.DockerRunCommandFlags) []runmodule.RunMutation
+}
+
+// Optional interface for WrapperRunModules which can fail
+//   - HandleRunWithError(...) - called instead of HandleRun.  An error skips
+//     the module or denies the run, depending on its FailurePolicy()
+
+type WrapperRunModuleWithError interface {
+	WrapperRunModule
+	HandleRunWithError(dockerflags.DockerFlags, dockerflags.DockerRunCommandFlags) ([]string, error)
+}
+
+// RegisterRunModule registers a WrapperRunModule, see AdaptRunModule
+func RegisterRunModule(m WrapperRunModule) {
+	if m != nil {
+		RegisterModule(AdaptRunModule(m))
+	}
+}
+
+// AdaptRunModule wraps a WrapperRunModule as a RunModule.  The globals it
+// reads (dockerImage, mesosTaskId ...) are set from the RunContext before
+// each call by runModules, on the main goroutine (see
+// setLegacyModuleGlobals).
+func AdaptRunModule(m WrapperRunModule) runmodule.RunModule {
+	return &legacyRunModule{m}
+}
+
+// legacyRunModule implements RunModule and all the optional interfaces for
+// a WrapperRunModule, passing on to it whichever it implements
+type legacyRunModule struct {
+	module WrapperRunModule
+}
+
+func (l *legacyRunModule) Priority() int {
+	return l.module.Priority()
+}
+
+func (l *legacyRunModule) HandleRun(ctx *runmodule.RunContext) ([]string, error) {
+	if withError, ok := l.module.(WrapperRunModuleWithError); ok {
+		return withError.HandleRunWithError(ctx.Flags, ctx.RunFlags)
+	}
+	return l.module.HandleRun(ctx.Flags, ctx.RunFlags), nil
+}
+
+func (l *legacyRunModule) DenyRun(ctx *runmodule.RunContext) string {
+	if denier, ok := l.module.(WrapperRunDenier); ok {
+		return denier.DenyRun(ctx.Flags, ctx.RunFlags)
+	}
+	return ""
+}
+
+func (l *legacyRunModule) deniesRuns() bool {
+	_, ok := l.module.(WrapperRunDenier)
+	return ok
+}
+
+func (l *legacyRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	if mutator, ok := l.module.(WrapperRunMutator); ok {
+		return mutator.MutateRun(ctx.Flags, ctx.RunFlags)
+	}
+	return nil
+}
+
+func (l *legacyRunModule) ModuleName() string {
+	return runModuleName(l.module)
+}
+
+func (l *legacyRunModule) Timeout() time.Duration {
+	if handler, ok := l.module.(runmodule.RunFailureHandler); ok {
+		return handler.Timeout()
+	}
+	return 0
+}
+
+func (l *legacyRunModule) FailurePolicy() runmodule.FailurePolicy {
+	if handler, ok := l.module.(runmodule.RunFailureHandler); ok {
+		return handler.FailurePolicy()
+	}
+	return runmodule.FailOpen
+}
+
+func (l *legacyRunModule) RunAfter() []string {
+	if ordering, ok := l.module.(runmodule.RunOrdering); ok {
+		return ordering.RunAfter()
+	}
+	return nil
+}
+
+func (l *legacyRunModule) RunBefore() []string {
+	if ordering, ok := l.module.(runmodule.RunOrdering); ok {
+		return ordering.RunBefore()
+	}
+	return nil
+}
+
+func (l *legacyRunModule) AllowsOptOut() bool {
+	if policy, ok := l.module.(runmodule.RunOptOutPolicy); ok {
+		return policy.AllowsOptOut()
+	}
+	return true
+}
+
+// setLegacyModuleGlobals sets the globals for mod, if it is a
+// WrapperRunModule, before it is called.  Only the wrapper's main goroutine
+// may write them, never a module's.
+func setLegacyModuleGlobals(mod runmodule.RunModule, ctx *runmodule.RunContext) {
+	if _, ok := mod.(*legacyRunModule); ok {
+		setLegacyGlobals(ctx)
+	}
+}
+
+// setLegacyGlobals sets the globals WrapperRunModules read from the context
+func setLegacyGlobals(ctx *runmodule.RunContext) {
+	dockerFlags = ctx.Flags
+	dockerRunFlags = ctx.RunFlags
+	dockerImage = ctx.Image
+	mesosTaskId = ctx.MesosTaskId
+	marathonAppId = ctx.MarathonAppId
+}
@@ -0,0 +1,340 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// log destinations (file, syslog, journald, stderr) with levels and a
+// fallback chain - logging must never stop us from exec'ing docker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultLogFile = "/var/log/docker-wrapper.log"
+
+	// comma separated chain of log targets, the first one that works is
+	// used and the next one takes over if writing fails:
+	//   file:/path (or just /path), syslog, journald, stderr
+	LogTargetEnv = "DOCKER_WRAPPER_LOG"
+	// minimum level logged: debug, info, warn, error
+	LogLevelEnv = "DOCKER_WRAPPER_LOG_LEVEL"
+
+	DefaultLogTarget      = "file:" + DefaultLogFile + ",stderr"
+	DefaultDebugLogTarget = "stderr"
+
+	JournaldSocket = "/run/systemd/journal/socket"
+	LogIdentifier  = "docker-wrapper"
+)
+
+// log levels, taken from the "DEBUG:", "WARN:" ... marker of each log line
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// line markers, lines without one are info
+var logLevelMarkers = []struct {
+	marker string
+	level  logLevel
+}{
+	{"DEBUG:", levelDebug},
+	{"INFO:", levelInfo},
+	{"WARN:", levelWarn},
+	{"DENY:", levelWarn},
+	{"ERROR:", levelError},
+}
+
+// LoggingConfig sets the log target chain and level from the config file,
+// the env vars take precedence
+type LoggingConfig struct {
+	Target string `json:"target"`
+	Level  string `json:"level"`
+}
+
+// the current log output, closed by teardownLogging
+var logOutput *leveledLogWriter
+
+// setupLogging logs to the chain from the env (stderr when debugging, else
+// the log file falling back to stderr), using date, time and filename
+func setupLogging() {
+	configureLogging(os.Getenv(LogTargetEnv), os.Getenv(LogLevelEnv))
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+// applyLoggingConfig switches to the config file logging, unless set by env
+func applyLoggingConfig(config *LoggingConfig) {
+	if config == nil {
+		return
+	}
+	target, level := os.Getenv(LogTargetEnv), os.Getenv(LogLevelEnv)
+	if target == "" {
+		target = config.Target
+	}
+	if level == "" {
+		level = config.Level
+	}
+	configureLogging(target, level)
+}
+
+// configureLogging replaces the log output with the target chain and level,
+// empty values use the defaults
+func configureLogging(target string, level string) {
+	if target == "" {
+		target = DefaultLogTarget
+		if isDebugEnabled() {
+			target = DefaultDebugLogTarget
+		}
+	}
+
+	minLevel := levelInfo
+	if isDebugEnabled() {
+		minLevel = levelDebug
+	}
+	if level != "" {
+		if named, ok := logLevelNames[strings.ToLower(level)]; ok {
+			minLevel = named
+		} else {
+			defer log.Printf("WARN: unknown log level %q", level)
+		}
+	}
+
+	output := &leveledLogWriter{minLevel: minLevel, targets: strings.Split(target, ",")}
+	output.nextSink()
+
+	teardownLogging()
+	logOutput = output
+	log.SetOutput(output)
+}
+
+func teardownLogging() {
+	if logOutput != nil {
+		logOutput.Close()
+		logOutput = nil
+	}
+}
+
+// ********************
+
+// logSink writes a single log line somewhere
+type logSink interface {
+	WriteLevel(level logLevel, line []byte) error
+	Close() error
+}
+
+// openLogSink opens a single log target
+func openLogSink(target string) (logSink, error) {
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "stderr":
+		return stderrSink{}, nil
+	case target == "syslog":
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, LogIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		return &syslogSink{writer}, nil
+	case target == "journald":
+		conn, err := net.Dial("unixgram", JournaldSocket)
+		if err != nil {
+			return nil, err
+		}
+		return &journaldSink{conn}, nil
+	case strings.HasPrefix(target, "file:") || strings.HasPrefix(target, "/"):
+		file, err := os.OpenFile(strings.TrimPrefix(target, "file:"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		restrictLogFile(file)
+		return &fileSink{file}, nil
+	}
+	return nil, fmt.Errorf("docker-wrapper: unknown log target %q", target)
+}
+
+// restrictLogFile takes the group write and other access away from a log
+// file we own, files from older versions were created world readable
+func restrictLogFile(file *os.File) {
+	info, err := file.Stat()
+	if err != nil || info.Mode().Perm()&^0640 == 0 {
+		return
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == os.Geteuid() {
+		file.Chmod(info.Mode().Perm() & 0640)
+	}
+}
+
+// leveledLogWriter is the log package output: it drops lines below minLevel
+// and writes the rest to the current sink, moving down the target chain when
+// a sink fails.  Writes never fail.
+type leveledLogWriter struct {
+	sync.Mutex
+	minLevel logLevel
+	targets  []string // not yet tried
+	sink     logSink
+}
+
+// nextSink opens the next working target, nil sink when none are left.  The
+// targets which failed are logged to it at debug level: a missing or
+// unwritable log file is normal for users other than root.
+func (w *leveledLogWriter) nextSink() {
+	if w.sink != nil {
+		w.sink.Close()
+		w.sink = nil
+	}
+	failed := []string{}
+	for len(w.targets) > 0 {
+		target := w.targets[0]
+		w.targets = w.targets[1:]
+		sink, err := openLogSink(target)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s DEBUG: unable to log to %q, trying next: %v\n",
+				time.Now().Format("2006/01/02 15:04:05"), target, err))
+			continue
+		}
+		w.sink = sink
+		if w.minLevel <= levelDebug {
+			for _, line := range failed {
+				sink.WriteLevel(levelDebug, []byte(line))
+			}
+		}
+		return
+	}
+}
+
+func (w *leveledLogWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	level := lineLevel(p)
+	if level < w.minLevel {
+		return len(p), nil
+	}
+	for w.sink != nil {
+		if err := w.sink.WriteLevel(level, p); err == nil {
+			break
+		}
+		w.nextSink()
+	}
+	return len(p), nil
+}
+
+func (w *leveledLogWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.sink != nil {
+		return w.sink.Close()
+	}
+	return nil
+}
+
+// lineLevel finds the level marker of a log line (after the date and file
+// name prefix)
+func lineLevel(line []byte) logLevel {
+	for _, level := range logLevelMarkers {
+		if bytes.Contains(line, []byte(" "+level.marker)) || bytes.HasPrefix(line, []byte(level.marker)) {
+			return level.level
+		}
+	}
+	return levelInfo
+}
+
+// ********************
+
+type stderrSink struct{}
+
+func (stderrSink) WriteLevel(level logLevel, line []byte) error {
+	_, err := os.Stderr.Write(line)
+	return err
+}
+
+func (stderrSink) Close() error { return nil }
+
+type fileSink struct {
+	file *os.File
+}
+
+func (s *fileSink) WriteLevel(level logLevel, line []byte) error {
+	_, err := s.file.Write(line)
+	return err
+}
+
+func (s *fileSink) Close() error { return s.file.Close() }
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func (s *syslogSink) WriteLevel(level logLevel, line []byte) error {
+	message := string(line)
+	switch level {
+	case levelDebug:
+		return s.writer.Debug(message)
+	case levelWarn:
+		return s.writer.Warning(message)
+	case levelError:
+		return s.writer.Err(message)
+	}
+	return s.writer.Info(message)
+}
+
+func (s *syslogSink) Close() error { return s.writer.Close() }
+
+// journaldSink speaks the journald native protocol over its datagram socket
+type journaldSink struct {
+	conn net.Conn
+}
+
+// syslog priorities for journald PRIORITY=
+var journaldPriorities = map[logLevel]int{
+	levelDebug: 7,
+	levelInfo:  6,
+	levelWarn:  4,
+	levelError: 3,
+}
+
+func (s *journaldSink) WriteLevel(level logLevel, line []byte) error {
+	_, err := s.conn.Write(journaldMessage(journaldPriorities[level], bytes.TrimRight(line, "\n")))
+	return err
+}
+
+func (s *journaldSink) Close() error { return s.conn.Close() }
+
+// journaldMessage encodes a journal entry, multi-line messages need the
+// binary length-prefixed form
+func journaldMessage(priority int, message []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\n", priority, LogIdentifier)
+	if bytes.Contains(message, []byte("\n")) {
+		buf.WriteString("MESSAGE\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(message)))
+		buf.Write(message)
+		buf.WriteString("\n")
+	} else {
+		buf.WriteString("MESSAGE=")
+		buf.Write(message)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
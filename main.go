@@ -8,67 +8,167 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"sort"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
 )
 
 var (
-	// parsed out of DockerRunCommandFlags.Args.Image
-	dockerFullImageName string // globals to store imagename:tag from {{docker run}} command line (see run_cmd.go)
-	dockerImageName     string
-	dockerImageTag      string
+	// parsed out of DockerRunCommandFlags.Args.Image (see docker_flags.go).
+	// Deprecated: for WrapperRunModules only, use RunContext.Image
+	dockerImage imageref.ImageRef
 
-	// from -e ENV vars
+	// from -e ENV vars.
+	// Deprecated: for WrapperRunModules only, use the RunContext
 	mesosTaskId   string
 	marathonAppId string
 )
 
-// if debug is on logging goes to stdout/stderr, else setup logging to append
-// to file ...
-var logFile *os.File
-
 // ********************
 
-// Module interface for docker wrapper Run modules
-//   - Priority()  - a way to set order of operation - sorted in ascending order for execution
-//   - HandleRun(...) - handle any run-command context, setting global vars as needed and return new docker run args to inject
+// exit code for a denied run, same as docker run uses for its own errors
+const RunDeniedExitCode = 125
+
+// Deprecated: the module interfaces live in runmodule, these names are
+// kept for existing modules
+type (
+	WrapperRunFailureHandler = runmodule.RunFailureHandler
+	WrapperRunOrdering       = runmodule.RunOrdering
+)
 
-type WrapperRunModule interface {
-	Priority() int
-	HandleRun(DockerFlags, DockerRunCommandFlags) []string
+// config file names of the failure policies
+var failurePolicyNames = map[string]runmodule.FailurePolicy{
+	"allow": runmodule.FailOpen,
+	"deny":  runmodule.FailClosed,
 }
 
-// plural for sorting purposes
-type WrapperRunModules []WrapperRunModule
+// parseFailurePolicy parses "allow" or "deny", "" is FailOpen
+func parseFailurePolicy(name string) (runmodule.FailurePolicy, error) {
+	if name == "" {
+		return runmodule.FailOpen, nil
+	}
+	if policy, ok := failurePolicyNames[name]; ok {
+		return policy, nil
+	}
+	return runmodule.FailOpen, fmt.Errorf("docker-wrapper: unknown failure policy %q", name)
+}
+
+// default for modules without a Timeout()
+const DefaultRunModuleTimeout = 10 * time.Second
 
-// define sort.Interface using Priority() to sort module list
-func (mods WrapperRunModules) Len() int      { return len(mods) }
-func (mods WrapperRunModules) Swap(i, j int) { mods[i], mods[j] = mods[j], mods[i] }
-func (mods WrapperRunModules) Less(i, j int) bool {
-	return mods[i].Priority() < mods[j].Priority()
+// plural for sorting purposes
+type RunModules []runmodule.RunModule
+
+// define sort.Interface using Priority() to sort module list, equal
+// priorities by name (see also sortRunModules)
+func (mods RunModules) Len() int      { return len(mods) }
+func (mods RunModules) Swap(i, j int) { mods[i], mods[j] = mods[j], mods[i] }
+func (mods RunModules) Less(i, j int) bool {
+	if mods[i].Priority() != mods[j].Priority() {
+		return mods[i].Priority() < mods[j].Priority()
+	}
+	return runModuleName(mods[i]) < runModuleName(mods[j])
 }
 
 // the known list of modules for docker run
-var registeredRunModules WrapperRunModules
+var registeredRunModules RunModules
 
 // modules need to call this to register themselves
-func RegisterRunModule(m WrapperRunModule) {
+func RegisterModule(m runmodule.RunModule) {
 	if m != nil {
 		registeredRunModules = append(registeredRunModules, m)
 	}
 }
 
-// provide a simple pseudo Abstract example impl
+// provide a simple pseudo Abstract example impl, implementing RunModule
+// and the optional interfaces from its fields
 type DefaultRunModule struct {
-	Name     string
-	priority int
+	Name        string
+	RunPriority int
+	RunTimeout  time.Duration           // 0 for the default
+	OnFailure   runmodule.FailurePolicy // see FailurePolicy
+	After       []string                // see RunAfter
+	Before      []string                // see RunBefore
+	NoOptOut    bool                    // see AllowsOptOut
 }
 
-func (d *DefaultRunModule) HandleRun(flags DockerFlags, runFlags DockerRunCommandFlags) []string {
-	return []string{}
+func (d *DefaultRunModule) HandleRun(ctx *runmodule.RunContext) ([]string, error) {
+	return []string{}, nil
 }
 
 func (d *DefaultRunModule) Priority() int {
-	return d.priority
+	return d.RunPriority
+}
+
+func (d *DefaultRunModule) ModuleName() string {
+	return d.Name
+}
+
+func (d *DefaultRunModule) Timeout() time.Duration {
+	return d.RunTimeout
+}
+
+func (d *DefaultRunModule) FailurePolicy() runmodule.FailurePolicy {
+	return d.OnFailure
+}
+
+func (d *DefaultRunModule) RunAfter() []string {
+	return d.After
+}
+
+func (d *DefaultRunModule) RunBefore() []string {
+	return d.Before
+}
+
+// AllowsOptOut is false for policies containers must not avoid, see
+// runModuleSkipped
+func (d *DefaultRunModule) AllowsOptOut() bool {
+	return !d.NoOptOut
+}
+
+// runModuleName is the module Name if it has one, otherwise its type
+func runModuleName(mod interface{}) string {
+	if named, ok := mod.(runmodule.NamedRunModule); ok && named.ModuleName() != "" {
+		return named.ModuleName()
+	}
+	return fmt.Sprintf("%T", mod)
+}
+
+// runModuleFailureHandling is the timeout and failure policy for a module:
+// the config file for its name, else the module's own, else the defaults
+func runModuleFailureHandling(mod runmodule.RunModule) (time.Duration, runmodule.FailurePolicy) {
+	timeout, policy := time.Duration(0), runmodule.FailOpen
+	if handler, ok := mod.(runmodule.RunFailureHandler); ok {
+		timeout, policy = handler.Timeout(), handler.FailurePolicy()
+	}
+
+	if wrapperConfig != nil {
+		if config, ok := wrapperConfig.Modules[runModuleName(mod)]; ok {
+			if config.Timeout != "" {
+				if parsed, err := time.ParseDuration(config.Timeout); err == nil && parsed > 0 {
+					timeout = parsed
+				} else {
+					log.Printf("WARN: bad timeout %q for run module %q", config.Timeout, runModuleName(mod))
+				}
+			}
+			if config.OnFailure != "" {
+				if parsed, err := parseFailurePolicy(config.OnFailure); err == nil {
+					policy = parsed
+				} else {
+					log.Printf("WARN: %v for run module %q", err, runModuleName(mod))
+				}
+			}
+		}
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultRunModuleTimeout
+	}
+	return timeout, policy
 }
 
 // ********************
@@ -77,83 +177,344 @@ func (d *DefaultRunModule) Priority() int {
 //***********************************************************************
 
 func main() {
-	setupLogging()
-	defer teardownLogging()
-
 	// create new string slice without "docker-wrapper" first element, in case we need to add args
 	newDockerArgs := os.Args[1:]
 
+	// `docker-wrapper explain -- <docker args>` is a dry run
+	explain := isDryRunEnabled()
+	if len(newDockerArgs) > 0 && newDockerArgs[0] == ExplainCommand {
+		explain = true
+		newDockerArgs = explainDockerArgs(newDockerArgs)
+	}
+	originalDockerArgs := append([]string{}, newDockerArgs...)
+
+	// the config sets up logging and redaction for every command, so load it
+	// before anything is logged - only -D is needed from the args for that
+	setDebugFlag(isDebugRequested(newDockerArgs))
+	setupLogging()
+	defer teardownLogging()
+	config := loadWrapperConfig()
+
 	// using a command-line parsing library can help grab IMAGE name
 	// reliably but has it's own drawbacks: can be stale if new options are
 	// added and users attempt to use those new options
-	parseCommandlineArgs(newDockerArgs)
+	ctx := parseRunContext(newDockerArgs)
+
+	// for a docker run command (or create) we can add functionality here using modules
+	var results []moduleResult
+	if ctx != nil {
+		ctx.DryRun = explain
+
+		// rules from the config files run as built-in modules
+		registerConfigRunModules(config)
+		if err := checkRunModuleOrder(); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+
+		newDockerArgs, results = runParsedModules(ctx, newDockerArgs)
+	}
+
+	// docker pull only gets the image rewritten to its mirror, trying the
+	// fallbacks may have done the pull already
+	pulled := false
+	if isDockerPullCommand() {
+		if config != nil && len(config.Mirrors) > 0 {
+			newDockerArgs, pulled = rewritePullArgs(newDockerArgs, config.Mirrors, !explain)
+		}
+	}
+
+	if explain {
+		printExplain(os.Stdout, ctx, originalDockerArgs, results, newDockerArgs)
+		return
+	}
+	writeAuditRecord(newAuditRecord(ctx, originalDockerArgs, newDockerArgs, results))
+	if denial := deniedResult(results); denial != nil {
+		denyRun(ctx, denial.Denied)
+	}
+	if pulled {
+		return
+	}
 
+	// now exec docker for real
+	dockerExec(newDockerArgs)
+}
+
+// moduleResult records what a single module did to a docker run
+type moduleResult struct {
+	Name      string                  `json:"name"`
+	Priority  int                     `json:"priority"`
+	Skipped   string                  `json:"skipped,omitempty"`   // why the module did not run
+	Denied    string                  `json:"denied,omitempty"`    // reason, if the module denied the run
+	Error     string                  `json:"error,omitempty"`     // the module failed, see FailurePolicy
+	Mutations []runmodule.RunMutation `json:"mutations,omitempty"` // applied changes to the run args
+	Args      []string                `json:"args,omitempty"`      // injected args
+}
+
+// runParsedModules runs the modules for a run with a valid image.  A run
+// which did not parse, or has an invalid image, cannot be checked by policy
+// modules (see unparsedRunResults).
+func runParsedModules(ctx *runmodule.RunContext, args []string) ([]string, []moduleResult) {
+	if ctx.Image.IsZero() {
+		if ctx.RunFlags.Help {
+			return args, nil
+		}
+		return args, unparsedRunResults(ctx)
+	}
 	if isDebugEnabled() {
-		log.Printf("DEBUG: DOCKER IMAGE == %q", dockerImageName)
-		log.Printf("DEBUG: DOCKER TAG == %q", dockerImageTag)
+		log.Printf("DEBUG: DOCKER IMAGE == %q", ctx.Image.Repository())
+		log.Printf("DEBUG: DOCKER TAG == %q", ctx.Image.Tag)
+		log.Printf("DEBUG: DOCKER DIGEST == %q", ctx.Image.Digest)
+	}
+	return runModules(ctx, args)
+}
+
+// unparsedRunResults denies a run the modules cannot check if any module
+// which could deny it, or fails closed, is registered and not turned off in
+// the config - otherwise an unknown option would get a run past every
+// policy.  Each such module denies, other modules do not run.
+func unparsedRunResults(ctx *runmodule.RunContext) []moduleResult {
+	problem := runParseError
+	if problem == nil {
+		_, problem = imageref.ParseImageRef(ctx.RunFlags.Args.Image)
+	}
+
+	mods, _ := sortRunModules(registeredRunModules)
+	results := []moduleResult{}
+	for _, mod := range mods {
+		if runModuleDisabled(mod) {
+			continue
+		}
+		_, policy := runModuleFailureHandling(mod)
+		if !runModuleDenies(mod) && policy != runmodule.FailClosed {
+			continue
+		}
+		results = append(results, moduleResult{
+			Name:     runModuleName(mod),
+			Priority: mod.Priority(),
+			Denied:   fmt.Sprintf("unable to check the run: %v", problem),
+		})
+	}
+	if len(results) > 0 {
+		log.Printf("WARN: run args did not parse, denying: %v", problem)
 	}
+	return results
+}
 
-	// if we have an image and a docker run command, we can add functionality here using modules
-	if dockerImageName != "" && simpleIsDockerRunCommand(newDockerArgs) {
+// runModules runs each registered Run Module in order (see sortRunModules)
+// against the run, returning the new docker args and what each module did.
+// ctx is updated as modules change the run.  A denial stops the modules, it
+// is the last result.
+func runModules(ctx *runmodule.RunContext, args []string) ([]string, []moduleResult) {
+	// a cycle was reported by checkRunModuleOrder
+	mods, _ := sortRunModules(registeredRunModules)
+
+	results := []moduleResult{}
+	injectArgs := []string{}
+	unmergedArgs := []string{} // injected args missing from ctx.RunFlags
+	mutated := false
+	for _, mod := range mods {
+		mod := mod // an abandoned module goroutine keeps its own
+		result := moduleResult{Name: runModuleName(mod), Priority: mod.Priority()}
+		if result.Skipped = runModuleSkipped(mod, ctx); result.Skipped != "" {
+			results = append(results, result)
+			continue
+		}
+		timeout, policy := runModuleFailureHandling(mod)
+		deadline := time.Now().Add(timeout)
+
+		// to undo the module's changes if it fails
+		saved := *ctx
+
+		// policy modules get a chance to refuse the whole run, and any
+		// changes to the existing run args are applied.  Modules get their
+		// own copy of the context, nothing they hold on to after a timeout
+		// is shared.
+		var reason string
+		var mutations []runmodule.RunMutation
+		modCtx := ctx.Copy()
+		modCtx.Deadline = deadline
+		setLegacyModuleGlobals(mod, ctx)
+		err := callRunModule(deadline, func() error {
+			if denier, ok := mod.(runmodule.RunDenier); ok {
+				if reason = denier.DenyRun(modCtx); reason != "" {
+					return nil
+				}
+			}
+			if mutator, ok := mod.(runmodule.RunMutator); ok {
+				mutations = mutator.MutateRun(modCtx)
+			}
+			return nil
+		})
+		if err == nil && reason != "" {
+			result.Denied = reason
+			results = append(results, result)
+			return args, results
+		}
+		modMutated := false
+		if err == nil {
+			// later modules see the changes
+			modMutated = mutateRunFlags(ctx, result.Name, mutations)
 
-		// for each registered Run Module -- run them in Priority order
-		sort.Sort(registeredRunModules)
-		for _, mod := range registeredRunModules {
 			// run the module and collect any new docker run params to inject
-			modArgs := mod.HandleRun(dockerFlags, dockerRunFlags)
-			if modArgs != nil && len(modArgs) > 0 {
-				newDockerArgs = injectRunArgs(newDockerArgs, modArgs)
+			var modArgs []string
+			modCtx := ctx.Copy()
+			modCtx.Deadline = deadline
+			setLegacyModuleGlobals(mod, ctx)
+			err = callRunModule(deadline, func() (err error) {
+				modArgs, err = mod.HandleRun(modCtx)
+				return err
+			})
+			if err == nil && len(modArgs) > 0 {
+				// later modules' args go first, right after "run"
+				injectArgs = append(append([]string{}, modArgs...), injectArgs...)
+				result.Args = modArgs
+				if !mergeInjectedArgs(ctx, result.Name, modArgs) {
+					unmergedArgs = append(append([]string{}, modArgs...), unmergedArgs...)
+				}
 			}
 		}
 
+		if err != nil {
+			log.Printf("ERROR: run module %q failed: %v", result.Name, err)
+			result.Error = err.Error()
+			if policy == runmodule.FailClosed {
+				result.Denied = fmt.Sprintf("run module %q failed: %v", result.Name, err)
+				results = append(results, result)
+				return args, results
+			}
+			*ctx = saved
+			modMutated = false
+		}
+		if modMutated {
+			result.Mutations = mutations
+			mutated = true
+		}
+		results = append(results, result)
 	}
 
-	// now exec docker for real
-	dockerExec(newDockerArgs)
+	// re-serialize the run args if they were changed, which takes in the
+	// injected args merged into the run flags, then inject the rest
+	if mutated {
+		args = dockerflags.ReplaceRunArgs(args, dockerflags.SerializeRunFlags(ctx.RunFlags))
+		injectArgs = unmergedArgs
+	}
+	if len(injectArgs) > 0 && isDebugEnabled() {
+		log.Printf("DEBUG: request to inject args: %q", redactArgs(injectArgs))
+	}
+	return dockerflags.InjectRunArgs(args, injectArgs), results
+}
+
+// callRunModule calls a module in its own goroutine, so a panic or a module
+// still running at the deadline is an error instead of the end of the
+// wrapper.  A timed out module is abandoned, its results must not be used.
+func callRunModule(deadline time.Time, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("ERROR: run module panic: %v\n%s", r, debug.Stack())
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- call()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timed out")
+	}
+}
+
+// deniedResult finds the module result which denied the run, if any
+func deniedResult(results []moduleResult) *moduleResult {
+	for i := range results {
+		if results[i].Denied != "" {
+			return &results[i]
+		}
+	}
+	return nil
 }
 
 //***************************************************************************
 //***************************************************************************
 
-// isDebugEnabled checks for --debug or DOCKER_WRAPPER_DEBUG env var.
-// used in setupLogging() and elsewhere.
-func isDebugEnabled() bool {
-	return (os.Getenv("DOCKER_WRAPPER_DEBUG") == "1") || dockerFlags.Debug
+// mergeInjectedArgs records the args a module injects in the run flags, as
+// set by module, so later modules and the explain output see them.  Returns
+// false if the args could not be merged and still need injecting as-is.
+func mergeInjectedArgs(ctx *runmodule.RunContext, module string, injectArgs []string) bool {
+	mutations, err := runmodule.InjectedRunMutations(ctx, injectArgs)
+	if err != nil {
+		log.Printf("WARN: run module %q injects args the wrapper cannot parse: %v", module, err)
+		return false
+	}
+	return len(mutations) == 0 || mutateRunFlags(ctx, module, mutations)
 }
 
-// attempt to log to a file - otherwise stdout
-func setupLogging() {
-	if !isDebugEnabled() {
-		// TODO: allow override - possibly via ENV DOCKER_WRAPPER_LOG=...
-		logFileName := "/var/log/docker-wrapper.log"
-		logFile, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			// check if we can write to directory - otherwise just log to stderr?
-			if os.IsPermission(err) {
-				log.Printf("WARN: Error opening logfile, fallback to STDERR: %v", err)
-			} else {
-				panic(err)
-			}
-		} else {
-			// moved close to teardownLogging()
-			//defer f.Close()
-			log.SetOutput(logFile)
+// mutateRunFlags applies module mutations to a copy of the run flags and
+// keeps the result only if all of them applied cleanly, recording the changed
+// options as set by module.  Returns true if the run flags changed.
+func mutateRunFlags(ctx *runmodule.RunContext, module string, mutations []runmodule.RunMutation) bool {
+	if len(mutations) == 0 {
+		return false
+	}
+	if !dockerflags.CanSerializeRunFlags(ctx.RunFlags) {
+		log.Printf("WARN: unable to rewrite run args for image %q, ignoring mutations %+v", ctx.RunFlags.Args.Image, redactMutations(mutations))
+		return false
+	}
+
+	if isDebugEnabled() {
+		for _, mutation := range redactMutations(mutations) {
+			log.Printf("DEBUG: applying run mutation: %+v", mutation)
 		}
 	}
+	runFlags := ctx.RunFlags
+	if err := runmodule.ApplyRunMutations(&runFlags, mutations); err != nil {
+		log.Printf("WARN: ignoring run mutations: %v", err)
+		return false
+	}
+	if err := ctx.SetRunFlags(runFlags); err != nil {
+		log.Printf("WARN: ignoring run mutations: %v", err)
+		return false
+	}
+	ctx.SetModuleProvenance(module, mutations)
+	return true
+}
 
-	// use date, time and filename for log output
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+// denyRun logs and reports a refused docker run on stderr and exits without
+// calling docker
+func denyRun(ctx *runmodule.RunContext, reason string) {
+	log.Printf("DENY: docker run of %q denied (MESOS_TASK_ID=%q MARATHON_APP_ID=%q): %s",
+		ctx.Image.String(), ctx.MesosTaskId, ctx.MarathonAppId, reason)
+	fmt.Fprintf(os.Stderr, "docker-wrapper: docker run denied: %s\n", reason)
+	teardownLogging()
+	os.Exit(RunDeniedExitCode)
 }
 
-func teardownLogging() {
-	// flush and close the file?
-	if logFile != nil {
-		logFile.Close()
+// --debug from the last parse, 1 if set.  Run module goroutines check it
+// too, so it is not read from dockerFlags.
+var debugFlag int32
+
+// setDebugFlag records --debug for isDebugEnabled
+func setDebugFlag(debug bool) {
+	var value int32
+	if debug {
+		value = 1
 	}
+	atomic.StoreInt32(&debugFlag, value)
+}
+
+// isDebugEnabled checks for --debug or DOCKER_WRAPPER_DEBUG env var.
+// used in setupLogging() and elsewhere.
+func isDebugEnabled() bool {
+	return (os.Getenv("DOCKER_WRAPPER_DEBUG") == "1") || atomic.LoadInt32(&debugFlag) == 1
 }
 
 func printHelpText() {
-	fmt.Println("Usage: docker-wrapper [OPTIONS] COMMAND [arg...]\n\nA Thin wrapper around docker\n")
+	fmt.Print("Usage: docker-wrapper [OPTIONS] COMMAND [arg...]\n\nA Thin wrapper around docker\n\n")
 }
 
 func printVersionText() {
@@ -0,0 +1,158 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// memory defaults derived from the -m Mesos passes: swap, a soft limit
+// (reservation) and how readily the kernel OOM kills the container
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// the memory policy is worked out from the final memory limit
+const MemoryPolicyModulePriority = -150
+
+// MemoryPolicyConfig turns on the memory policy.  The MemoryPolicy applies to
+// every run, the first of Apps matching the Marathon app id overrides it.
+type MemoryPolicyConfig struct {
+	Enabled bool `json:"enabled"`
+	MemoryPolicy
+
+	// oom-score-adj for each priority class, e.g. {"batch": 500}
+	PriorityClasses map[string]int64 `json:"priority_classes"`
+
+	Apps []AppMemoryPolicy `json:"apps"`
+}
+
+// MemoryPolicy is what to derive from the memory limit, empty fields are left
+// alone
+type MemoryPolicy struct {
+	// "none" (memory-swap = memory), "unlimited" (-1) or a size of swap on
+	// top of the memory, e.g. "512m"
+	Swap string `json:"swap"`
+
+	// memory-reservation as a fraction of the memory, e.g. 0.75
+	ReservationFraction float64 `json:"reservation_fraction"`
+
+	// sets oom-score-adj from the PriorityClasses
+	PriorityClass string `json:"priority_class"`
+}
+
+// AppMemoryPolicy is the memory policy for Marathon apps matching a pattern
+// (see matchPattern)
+type AppMemoryPolicy struct {
+	MarathonAppId string `json:"marathon_app_id"`
+	MemoryPolicy
+}
+
+// override takes the fields set in other
+func (policy MemoryPolicy) override(other MemoryPolicy) MemoryPolicy {
+	if other.Swap != "" {
+		policy.Swap = other.Swap
+	}
+	if other.ReservationFraction != 0 {
+		policy.ReservationFraction = other.ReservationFraction
+	}
+	if other.PriorityClass != "" {
+		policy.PriorityClass = other.PriorityClass
+	}
+	return policy
+}
+
+// appPolicy is the policy for a Marathon app
+func (config *MemoryPolicyConfig) appPolicy(appId string) MemoryPolicy {
+	for _, app := range config.Apps {
+		if appId != "" && matchPattern(app.MarathonAppId, appId) {
+			return config.MemoryPolicy.override(app.MemoryPolicy)
+		}
+	}
+	return config.MemoryPolicy
+}
+
+// memorySwap works out memory-swap (memory plus swap) for memory bytes
+func (policy MemoryPolicy) memorySwap(memory int64) (int64, error) {
+	switch policy.Swap {
+	case "none":
+		return memory, nil
+	case "unlimited":
+		return -1, nil
+	}
+	swap, err := dockerflags.ParseSize(policy.Swap)
+	if err != nil {
+		return 0, fmt.Errorf("docker-wrapper: invalid memory policy swap %q", policy.Swap)
+	}
+	return memory + swap, nil
+}
+
+// ********************
+
+// MemoryPolicyRunModule adds --memory-swap, --memory-reservation and
+// --oom-score-adj to runs which do not set them
+type MemoryPolicyRunModule struct {
+	DefaultRunModule
+	config MemoryPolicyConfig
+}
+
+// NewMemoryPolicyRunModule creates the run module for the policy config
+func NewMemoryPolicyRunModule(config MemoryPolicyConfig) *MemoryPolicyRunModule {
+	return &MemoryPolicyRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "memory-policy", RunPriority: MemoryPolicyModulePriority},
+		config:           config,
+	}
+}
+
+// MutateRun implements the RunMutator interface, setting swap, reservation
+// and OOM score from the app's policy where the run has not.  Swap and
+// reservation need a memory limit; the priority class applies to any run.
+func (m *MemoryPolicyRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	policy := m.config.appPolicy(ctx.MarathonAppId)
+	mutations := []runmodule.RunMutation{}
+
+	memory, hasMemory, err := dockerflags.RunResource(ctx.RunFlags, "memory")
+	if err != nil {
+		log.Printf("WARN: not applying the memory policy: %v", err)
+		return nil
+	}
+
+	if hasMemory && policy.Swap != "" && !runFlagGiven(ctx.RunFlags, "memory-swap") {
+		if swap, err := policy.memorySwap(memory); err != nil {
+			log.Printf("WARN: %v", err)
+		} else {
+			mutations = append(mutations, runmodule.ReplaceResource("memory-swap", swap))
+		}
+	}
+
+	fraction := policy.ReservationFraction
+	if hasMemory && fraction != 0 && !runFlagGiven(ctx.RunFlags, "memory-reservation") {
+		if fraction < 0 || fraction > 1 {
+			log.Printf("WARN: invalid memory policy reservation fraction %v", fraction)
+		} else {
+			mutations = append(mutations, runmodule.ReplaceResource("memory-reservation", int64(float64(memory)*fraction)))
+		}
+	}
+
+	if policy.PriorityClass != "" && !runFlagGiven(ctx.RunFlags, "oom-score-adj") {
+		if score, ok := m.config.PriorityClasses[policy.PriorityClass]; ok {
+			mutations = append(mutations, runmodule.ReplaceResource("oom-score-adj", score))
+		} else {
+			log.Printf("WARN: unknown memory policy priority class %q", policy.PriorityClass)
+		}
+	}
+
+	if isDebugEnabled() && len(mutations) > 0 {
+		log.Printf("DEBUG: memory policy for %q: %v", ctx.MarathonAppId, mutations)
+	}
+	return mutations
+}
+
+// runFlagGiven checks the run already has an option, even with a value
+// docker would refuse - docker will say so
+func runFlagGiven(runFlags dockerflags.DockerRunCommandFlags, longName string) bool {
+	return len(dockerflags.RunFlagValues(runFlags, longName)) > 0
+}
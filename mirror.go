@@ -0,0 +1,212 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// registry mirror and image alias rewriting for docker run and docker pull
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/imageref"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	// mirrors rewrite the image before any other module sees it
+	MirrorModulePriority = -1000
+
+	// the mirror module may have to pull the image from its fallbacks
+	MirrorModuleTimeout = 2 * time.Minute
+)
+
+// ImageMirror maps image repositories matching From to To.  Each '*' in From
+// is substituted for the matching '*' in To, e.g.
+//   old-registry.yp.com/* => registry.internal/*
+// Fallbacks are tried in order when the To image is not available.  The
+// image tag and digest are kept.
+type ImageMirror struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Fallbacks []string `json:"fallbacks"`
+}
+
+// rewrite maps ref to its mirror (and fallback) images, returns nothing if
+// From does not match.  The repository is matched as given and normalized,
+// so "docker.io/library/*" matches "centos".
+func (mirror ImageMirror) rewrite(ref imageref.ImageRef) []imageref.ImageRef {
+	re, err := globRegexp(mirror.From, true)
+	if err != nil {
+		log.Printf("WARN: bad mirror pattern %q: %v", mirror.From, err)
+		return nil
+	}
+
+	var captured []string
+	for _, repository := range []string{ref.Repository(), ref.Normalized().Repository()} {
+		if matches := re.FindStringSubmatch(repository); matches != nil {
+			captured = matches[1:]
+			break
+		}
+	}
+	if captured == nil {
+		return nil
+	}
+
+	candidates := []imageref.ImageRef{}
+	for _, to := range append([]string{mirror.To}, mirror.Fallbacks...) {
+		for _, value := range captured {
+			to = strings.Replace(to, "*", value, 1)
+		}
+		candidate, err := imageref.ParseImageRef(to)
+		if err != nil {
+			log.Printf("WARN: bad mirror image %q: %v", to, err)
+			continue
+		}
+		candidate.Tag = ref.Tag
+		candidate.Digest = ref.Digest
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// mirrorCandidates returns the images to try for ref in order, using the
+// first matching mirror.  Returns nothing if no mirror applies.
+func mirrorCandidates(mirrors []ImageMirror, ref imageref.ImageRef) []imageref.ImageRef {
+	for _, mirror := range mirrors {
+		if candidates := mirror.rewrite(ref); len(candidates) > 0 {
+			return candidates
+		}
+	}
+	return nil
+}
+
+// ********************
+
+// MirrorRunModule rewrites the image of a docker run to its mirror
+type MirrorRunModule struct {
+	DefaultRunModule
+	mirrors []ImageMirror
+}
+
+// NewMirrorRunModule creates the run module for the configured mirrors
+func NewMirrorRunModule(mirrors []ImageMirror) *MirrorRunModule {
+	return &MirrorRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "registry-mirror", RunPriority: MirrorModulePriority, RunTimeout: MirrorModuleTimeout},
+		mirrors:          mirrors,
+	}
+}
+
+// MutateRun implements the RunMutator interface, replacing the image with
+// its mirror (see availableCandidate)
+func (m *MirrorRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	candidates := mirrorCandidates(m.mirrors, ctx.Image)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	image := candidates[0]
+	if len(candidates) > 1 {
+		image = availableCandidate(ctx, candidates)
+	}
+	log.Printf("INFO: mirror rewrites image %q => %q", ctx.Image.String(), image.String())
+	return []runmodule.RunMutation{runmodule.ReplaceImage(image.String())}
+}
+
+// availableCandidate picks the first candidate present locally.  Failing
+// that each candidate but the last is pulled in turn until one succeeds, so
+// a mirror outage falls back to the next, and docker run pulls the last
+// itself.  A dry run pulls nothing and uses the primary mirror.
+func availableCandidate(ctx *runmodule.RunContext, candidates []imageref.ImageRef) imageref.ImageRef {
+	for _, candidate := range candidates {
+		if _, err := dockerImageInspect(ctx.Flags, candidate.String()); err == nil {
+			return candidate
+		}
+	}
+	if ctx.DryRun {
+		return candidates[0]
+	}
+
+	for _, candidate := range candidates[:len(candidates)-1] {
+		err := dockerPull(ctx.Flags, candidate.String(), ctx.Deadline)
+		if err == nil {
+			return candidate
+		}
+		log.Printf("WARN: mirror pull of %q failed, trying next: %v", candidate.String(), err)
+	}
+	return candidates[len(candidates)-1]
+}
+
+// rewritePullArgs maps the image of a docker pull to its mirror.  With
+// fallbacks (and tryPull) the pull itself is run for each candidate but the
+// last in turn, and pulled is true once one succeeds: there is nothing left
+// to exec.  Otherwise the args returned pull the last candidate, or the
+// primary mirror without tryPull.
+func rewritePullArgs(args []string, mirrors []ImageMirror, tryPull bool) (newArgs []string, pulled bool) {
+	ref, err := imageref.ParseImageRef(dockerPullFlags.Args.Image)
+	if err != nil {
+		return args, false
+	}
+	candidates := mirrorCandidates(mirrors, ref)
+	if len(candidates) == 0 {
+		return args, false
+	}
+
+	if !tryPull {
+		return dockerflags.ReplacePullImage(args, parsedArgs.PullImageIndex, candidates[0].String()), false
+	}
+
+	for _, candidate := range candidates[:len(candidates)-1] {
+		candidateArgs := dockerflags.ReplacePullImage(args, parsedArgs.PullImageIndex, candidate.String())
+		err := dockerCommand(candidateArgs)
+		if err == nil {
+			log.Printf("INFO: mirror rewrote pull %q => %q", ref.String(), candidate.String())
+			return candidateArgs, true
+		}
+		log.Printf("WARN: mirror pull of %q failed, trying next: %v", candidate.String(), err)
+	}
+	image := candidates[len(candidates)-1]
+	log.Printf("INFO: mirror rewrites pull %q => %q", ref.String(), image.String())
+	return dockerflags.ReplacePullImage(args, parsedArgs.PullImageIndex, image.String()), false
+}
+
+// dockerPull pulls an image from the daemon dockerFlags connect to, sending
+// docker output to our stderr.  The pull is killed at deadline, zero for no
+// limit.
+func dockerPull(dockerFlags dockerflags.DockerFlags, image string, deadline time.Time) error {
+	binary, err := findBinary("docker")
+	if err != nil {
+		return err
+	}
+	timeout, cancel := context.Background(), func() {}
+	if !deadline.IsZero() {
+		timeout, cancel = context.WithDeadline(timeout, deadline)
+	}
+	defer cancel()
+	cmd := exec.CommandContext(timeout, binary, append(dockerflags.ConnectionArgs(dockerFlags), "pull", image)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// dockerCommand runs docker with argv (without "docker" itself) and waits,
+// with our stdout and stderr
+func dockerCommand(argv []string) error {
+	binary, err := findBinary("docker")
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(binary, argv...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if isDebugEnabled() {
+		log.Printf("DEBUG: docker args: %q", redactArgs(cmd.Args))
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// turning run modules on and off per host, app and image from the config
+// file, and per container with an env var or label - to roll out a new
+// module gradually
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const (
+	// comma separated module names (or patterns, see matchPattern) a
+	// container opts out of, as a -e env var or a --label
+	ModuleOptOutEnv   = "DOCKER_WRAPPER_DISABLE"
+	ModuleOptOutLabel = "com.yp.docker-wrapper.disable"
+)
+
+// runModuleSkipped returns why a module is not to run for the current run,
+// "" to run it.  The config file decides first: a matching "disable" turns
+// the module off, otherwise "enabled" (default true) or a matching "enable".
+// Then the container can opt out, unless the module can deny the run, fails
+// closed or otherwise does not allow it - only "opt_out" in the config can
+// let containers avoid a policy.
+func runModuleSkipped(mod runmodule.RunModule, ctx *runmodule.RunContext) string {
+	name := runModuleName(mod)
+	config := ModuleConfig{}
+	if wrapperConfig != nil {
+		config = wrapperConfig.Modules[name]
+	}
+
+	for _, match := range config.Disable {
+		if match.matches(ctx) {
+			return "disabled by config"
+		}
+	}
+	enabled := config.Enabled == nil || *config.Enabled
+	if !enabled {
+		for _, match := range config.Enable {
+			if match.matches(ctx) {
+				enabled = true
+				break
+			}
+		}
+	}
+	if !enabled {
+		return "disabled by config"
+	}
+
+	_, failurePolicy := runModuleFailureHandling(mod)
+	optOut := !runModuleDenies(mod) && failurePolicy != runmodule.FailClosed
+	if policy, ok := mod.(runmodule.RunOptOutPolicy); ok && !policy.AllowsOptOut() {
+		optOut = false
+	}
+	if config.OptOut != nil {
+		optOut = *config.OptOut
+	}
+	if optOut {
+		if source := containerOptOut(ctx.RunFlags, name); source != "" {
+			return "opted out by " + source
+		}
+	}
+	return ""
+}
+
+// runModuleDisabled is true for a module the config turns off everywhere,
+// "enabled": false without any "enable" matches
+func runModuleDisabled(mod runmodule.RunModule) bool {
+	if wrapperConfig == nil {
+		return false
+	}
+	config := wrapperConfig.Modules[runModuleName(mod)]
+	return config.Enabled != nil && !*config.Enabled && len(config.Enable) == 0
+}
+
+// implemented by module types which only sometimes deny, e.g. a config rule
+// without a deny reason
+type partialRunDenier interface {
+	deniesRuns() bool
+}
+
+// runModuleDenies is true for modules which can deny a run
+func runModuleDenies(mod runmodule.RunModule) bool {
+	if partial, ok := mod.(partialRunDenier); ok {
+		return partial.deniesRuns()
+	}
+	_, ok := mod.(runmodule.RunDenier)
+	return ok
+}
+
+// containerOptOut checks the opt out env var and label for the module name,
+// returning the one which opted out
+func containerOptOut(runFlags dockerflags.DockerRunCommandFlags, name string) string {
+	sources := []struct {
+		values []string
+		source string
+	}{
+		{dockerflags.EnvValuesLike(runFlags.Env, ModuleOptOutEnv+"="), fmt.Sprintf("env %s", ModuleOptOutEnv)},
+		{dockerflags.EnvValuesLike(runFlags.Label, ModuleOptOutLabel+"="), fmt.Sprintf("label %s", ModuleOptOutLabel)},
+	}
+	for _, source := range sources {
+		for _, value := range source.values {
+			for _, pattern := range strings.Split(value, ",") {
+				// an empty pattern would match every module
+				if pattern = strings.TrimSpace(pattern); pattern != "" && matchPattern(pattern, name) {
+					return source.source
+				}
+			}
+		}
+	}
+	return ""
+}
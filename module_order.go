@@ -0,0 +1,155 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// run module ordering - named before/after constraints between modules, with
+// Priority and then the module name deciding the rest
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// runModuleOrdering is the module's own constraints plus the config file's
+func runModuleOrdering(mod runmodule.RunModule) (after []string, before []string) {
+	if ordering, ok := mod.(runmodule.RunOrdering); ok {
+		after = append(after, ordering.RunAfter()...)
+		before = append(before, ordering.RunBefore()...)
+	}
+	if wrapperConfig != nil {
+		if config, ok := wrapperConfig.Modules[runModuleName(mod)]; ok {
+			after = append(after, config.After...)
+			before = append(before, config.Before...)
+		}
+	}
+	return after, before
+}
+
+// sortRunModules orders modules so every before/after constraint holds.  Of
+// the modules free to run next the lowest Priority goes first, equal
+// priorities by name, then in registration order.  Modules caught in (or
+// behind) a cycle run last in that same order, and the cycle is returned as
+// an error.
+func sortRunModules(mods RunModules) (RunModules, error) {
+	byName := map[string][]int{}
+	for i, mod := range mods {
+		name := runModuleName(mod)
+		byName[name] = append(byName[name], i)
+	}
+
+	// successors[i] must run after i, pending[i] counts i's predecessors not
+	// yet sorted
+	successors := make([][]int, len(mods))
+	predecessors := make([][]int, len(mods))
+	pending := make([]int, len(mods))
+	addConstraint := func(first int, then int) {
+		if first != then {
+			successors[first] = append(successors[first], then)
+			predecessors[then] = append(predecessors[then], first)
+			pending[then]++
+		}
+	}
+	for i, mod := range mods {
+		after, before := runModuleOrdering(mod)
+		for _, name := range after {
+			if _, ok := byName[name]; !ok && isDebugEnabled() {
+				log.Printf("DEBUG: run module %q: no module %q to run after", runModuleName(mod), name)
+			}
+			for _, j := range byName[name] {
+				addConstraint(j, i)
+			}
+		}
+		for _, name := range before {
+			if _, ok := byName[name]; !ok && isDebugEnabled() {
+				log.Printf("DEBUG: run module %q: no module %q to run before", runModuleName(mod), name)
+			}
+			for _, j := range byName[name] {
+				addConstraint(i, j)
+			}
+		}
+	}
+
+	sorted := make(RunModules, 0, len(mods))
+	done := make([]bool, len(mods))
+	for len(sorted) < len(mods) {
+		next := -1
+		for i := range mods {
+			if !done[i] && pending[i] == 0 && (next == -1 || mods.Less(i, next)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+		done[next] = true
+		sorted = append(sorted, mods[next])
+		for _, j := range successors[next] {
+			pending[j]--
+		}
+	}
+	if len(sorted) == len(mods) {
+		return sorted, nil
+	}
+
+	cycle := findModuleCycle(mods, done, predecessors)
+	for len(sorted) < len(mods) {
+		next := -1
+		for i := range mods {
+			if !done[i] && (next == -1 || mods.Less(i, next)) {
+				next = i
+			}
+		}
+		done[next] = true
+		sorted = append(sorted, mods[next])
+	}
+	return sorted, fmt.Errorf("docker-wrapper: run module order cycle: %s", strings.Join(cycle, " -> "))
+}
+
+// checkRunModuleOrder looks for a before/after cycle between the registered
+// modules once they are all registered, rather than on every run
+func checkRunModuleOrder() error {
+	_, err := sortRunModules(registeredRunModules)
+	return err
+}
+
+// findModuleCycle walks back through the unsorted modules, each of which
+// has an unsorted predecessor, until it comes round to a module again.
+// Returns the names in run order, first and last the same.
+func findModuleCycle(mods RunModules, done []bool, predecessors [][]int) []string {
+	start := -1
+	for i := range mods {
+		if !done[i] {
+			start = i
+			break
+		}
+	}
+
+	seen := map[int]int{} // module => position in path
+	path := []int{}
+	for i := start; ; {
+		if at, ok := seen[i]; ok {
+			path = path[at:]
+			break
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, j := range predecessors[i] {
+			if !done[j] {
+				i = j
+				break
+			}
+		}
+	}
+
+	// path runs backwards from successor to predecessor
+	cycle := []string{runModuleName(mods[path[0]])}
+	for k := len(path) - 1; k >= 0; k-- {
+		cycle = append(cycle, runModuleName(mods[path[k]]))
+	}
+	return cycle
+}
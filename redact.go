@@ -0,0 +1,182 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// redaction of secrets (passwords, tokens ...) passed to containers, before
+// they reach the logs or the audit log
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+const RedactedValue = "<redacted>"
+
+// default KEY patterns (see matchPattern) matched case-insensitively against
+// KEY=value pairs and option names
+var DefaultRedactKeys = []string{
+	"*PASSWORD*", "*PASSWD*", "*TOKEN*", "*SECRET*", "*CREDENTIAL*", "*_KEY", "*APIKEY*",
+}
+
+// default regular expressions for secret looking values, the matching part
+// of any value is redacted
+var DefaultRedactValues = []string{
+	// AWS access key id
+	`AKIA[0-9A-Z]{16}`,
+	// JWT
+	`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[^-]*(-----END [A-Z ]*PRIVATE KEY-----)?`,
+	`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`,
+}
+
+// credentials in URLs, e.g. postgres://user:secret@db/name
+var urlPasswordRegexp = regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`)
+
+// RedactionConfig adds KEY patterns and value regular expressions to the
+// defaults
+type RedactionConfig struct {
+	Keys   []string `json:"keys"`
+	Values []string `json:"values"`
+}
+
+// redactor holds the compiled redaction rules
+type redactor struct {
+	keys   []string
+	values []*regexp.Regexp
+}
+
+// the redaction rules in use, extended by the config file
+var logRedactor = newRedactor(RedactionConfig{})
+
+// newRedactor compiles the defaults plus the config, skipping bad patterns
+func newRedactor(config RedactionConfig) *redactor {
+	r := &redactor{}
+	for _, key := range append(append([]string{}, DefaultRedactKeys...), config.Keys...) {
+		r.keys = append(r.keys, strings.ToUpper(key))
+	}
+	for _, expr := range append(append([]string{}, DefaultRedactValues...), config.Values...) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Printf("WARN: bad redaction pattern %q: %v", expr, err)
+			continue
+		}
+		r.values = append(r.values, re)
+	}
+	return r
+}
+
+// applyRedactionConfig extends the default redaction rules with the config
+func applyRedactionConfig(config *RedactionConfig) {
+	if config != nil {
+		logRedactor = newRedactor(*config)
+	}
+}
+
+// isSecretKey checks a KEY or option name against the key patterns
+func (r *redactor) isSecretKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range r.keys {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue redacts the secret looking parts of a value
+func (r *redactor) redactValue(value string) string {
+	value = urlPasswordRegexp.ReplaceAllString(value, "${1}"+RedactedValue+"@")
+	for _, re := range r.values {
+		value = re.ReplaceAllString(value, RedactedValue)
+	}
+	return value
+}
+
+// redactArg redacts KEY=value, --option=value and --option=KEY=value args
+func (r *redactor) redactArg(arg string) string {
+	if strings.HasPrefix(arg, "-") {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 2 {
+			if r.isSecretKey(strings.TrimLeft(parts[0], "-")) {
+				return parts[0] + "=" + RedactedValue
+			}
+			return parts[0] + "=" + r.redactArg(parts[1])
+		}
+		return r.redactValue(arg)
+	}
+
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) == 2 && r.isSecretKey(parts[0]) {
+		return parts[0] + "=" + RedactedValue
+	}
+	return r.redactValue(arg)
+}
+
+// redactArgs returns a redacted copy of args (or environ), an arg following
+// a secret option (e.g. --password secret) is redacted too, as is the
+// password of docker login -p
+func (r *redactor) redactArgs(args []string) []string {
+	if args == nil {
+		return nil
+	}
+	redacted := make([]string, len(args))
+	login := false
+	for i, arg := range args {
+		if i > 0 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "=") &&
+			(r.isSecretKey(strings.TrimLeft(args[i-1], "-")) || (login && args[i-1] == "-p")) {
+			redacted[i] = RedactedValue
+			continue
+		}
+		if login && strings.HasPrefix(arg, "-p") && arg != "-p" {
+			// -psecret or -p=secret
+			redacted[i] = "-p" + RedactedValue
+			if strings.HasPrefix(arg, "-p=") {
+				redacted[i] = "-p=" + RedactedValue
+			}
+			continue
+		}
+		login = login || arg == "login"
+		redacted[i] = r.redactArg(arg)
+	}
+	return redacted
+}
+
+// redactArgs redacts args (or environ) for logging with the current rules
+func redactArgs(args []string) []string {
+	return logRedactor.redactArgs(args)
+}
+
+// redactMutations redacts the values of run mutations for logging
+func redactMutations(mutations []runmodule.RunMutation) []runmodule.RunMutation {
+	redacted := make([]runmodule.RunMutation, len(mutations))
+	for i, mutation := range mutations {
+		redacted[i] = mutation
+		if logRedactor.isSecretKey(mutation.Flag) {
+			redacted[i].Values = []string{RedactedValue}
+		} else {
+			redacted[i].Values = redactArgs(mutation.Values)
+		}
+	}
+	return redacted
+}
+
+// redactModuleResults redacts module args and mutations for logging
+func redactModuleResults(results []moduleResult) []moduleResult {
+	if results == nil {
+		return nil
+	}
+	redacted := make([]moduleResult, len(results))
+	for i, result := range results {
+		redacted[i] = result
+		redacted[i].Args = redactArgs(result.Args)
+		if result.Mutations != nil {
+			redacted[i].Mutations = redactMutations(result.Mutations)
+		}
+	}
+	return redacted
+}
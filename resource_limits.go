@@ -0,0 +1,270 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// maximum resource limits per Marathon app and per host, clamped down or
+// denied
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/runmodule"
+)
+
+// limits are enforced before the CPU quota and memory policy work from them
+const ResourceLimitsModulePriority = -300
+
+const (
+	// what to do with a run over its limits
+	LimitActionClamp = "clamp"
+	LimitActionDeny  = "deny"
+
+	// the ulimit with a maximum
+	NofileUlimit = "nofile"
+)
+
+// what docker uses when a limited run option is not given, for those that
+// are not unlimited by default
+var resourceLimitDefaults = map[string]int64{
+	"cpu-shares": dockerflags.DefaultCpuShares,
+	"shm-size":   dockerflags.DefaultShmSize,
+}
+
+// ResourceLimitsConfig holds the maximum resource limits.  Host limits every
+// run on this host, the first of Apps matching the Marathon app id lowers
+// them further.  Action is "clamp" (the default) or "deny".
+type ResourceLimitsConfig struct {
+	Action string              `json:"action"`
+	Host   ResourceLimits      `json:"host"`
+	Apps   []AppResourceLimits `json:"apps"`
+}
+
+// ResourceLimits are maximum values, empty or 0 for no maximum
+type ResourceLimits struct {
+	Memory       string `json:"memory"` // e.g. "8g"
+	CpuShares    int64  `json:"cpu_shares"`
+	PidsLimit    int64  `json:"pids_limit"`
+	ShmSize      string `json:"shm_size"`      // e.g. "1g"
+	UlimitNofile int64  `json:"ulimit_nofile"` // hard limit
+}
+
+// AppResourceLimits are the limits for Marathon apps matching a pattern (see
+// matchPattern)
+type AppResourceLimits struct {
+	MarathonAppId string `json:"marathon_app_id"`
+	ResourceLimits
+}
+
+// maxes maps the run option long names to their maximum, "ulimit" for the
+// nofile ulimit.  Bad sizes are logged and have no maximum.
+func (limits ResourceLimits) maxes() map[string]int64 {
+	maxes := map[string]int64{}
+	for name, size := range map[string]string{"memory": limits.Memory, "shm-size": limits.ShmSize} {
+		if size == "" {
+			continue
+		}
+		if bytes, err := dockerflags.ParseSize(size); err != nil {
+			log.Printf("WARN: ignoring resource limit: %v", err)
+		} else if bytes > 0 {
+			maxes[name] = bytes
+		}
+	}
+	for name, max := range map[string]int64{"cpu-shares": limits.CpuShares, "pids-limit": limits.PidsLimit, "ulimit": limits.UlimitNofile} {
+		if max > 0 {
+			maxes[name] = max
+		}
+	}
+	return maxes
+}
+
+// runMaxes works out the limits for a Marathon app, the lower of the app and
+// host limits
+func (config *ResourceLimitsConfig) runMaxes(appId string) map[string]int64 {
+	maxes := config.Host.maxes()
+	for _, app := range config.Apps {
+		if appId != "" && matchPattern(app.MarathonAppId, appId) {
+			for name, max := range app.ResourceLimits.maxes() {
+				if hostMax, ok := maxes[name]; !ok || max < hostMax {
+					maxes[name] = max
+				}
+			}
+			break
+		}
+	}
+	return maxes
+}
+
+// resourceExcess is a run option over its limit, with the change to bring it
+// down to the limit.  An invalid value cannot be checked and is denied.
+type resourceExcess struct {
+	reason   string
+	mutation runmodule.RunMutation
+	invalid  bool
+}
+
+// checkLimits finds the run options over maxes, in name order, and those
+// with a value which does not parse
+func checkLimits(runFlags dockerflags.DockerRunCommandFlags, maxes map[string]int64) []resourceExcess {
+	names := []string{}
+	for name := range maxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	excesses := []resourceExcess{}
+	for _, name := range names {
+		max := maxes[name]
+		if name == "ulimit" {
+			if excess := checkNofileLimit(runFlags, max); excess != nil {
+				excesses = append(excesses, *excess)
+			}
+			continue
+		}
+
+		value, set, err := dockerflags.RunResource(runFlags, name)
+		if err != nil && isZeroResource(runFlags, name) {
+			// docker reads these as unset, though out of range for us
+			value, set, err = 0, false, nil
+		}
+		if err != nil {
+			excesses = append(excesses, resourceExcess{
+				reason:  fmt.Sprintf("--%s %q cannot be checked against the limit of %s", name, dockerflags.RunFlagValues(runFlags, name)[0], dockerflags.FormatResource(name, max)),
+				invalid: true,
+			})
+			continue
+		}
+		given := dockerflags.FormatResource(name, value)
+		if !set {
+			if value, set = resourceLimitDefaults[name]; set {
+				given = dockerflags.FormatResource(name, value) + " (default)"
+			}
+		}
+		// unset, -1 and a pids-limit of 0 are unlimited
+		unlimited := !set || value == -1 || (name == "pids-limit" && value == 0)
+		if unlimited {
+			given = "unlimited"
+		}
+		if unlimited || value > max {
+			excesses = append(excesses, resourceExcess{
+				reason:   fmt.Sprintf("--%s %s is over the limit of %s", name, given, dockerflags.FormatResource(name, max)),
+				mutation: runmodule.ReplaceResource(name, max),
+			})
+		}
+
+		// docker refuses a soft limit over the memory limit
+		if name == "memory" {
+			if reservation, set, _ := dockerflags.RunResource(runFlags, "memory-reservation"); set && reservation > max {
+				excesses = append(excesses, resourceExcess{
+					reason:   fmt.Sprintf("--memory-reservation %s is over the limit of %s", dockerflags.FormatSize(reservation), dockerflags.FormatSize(max)),
+					mutation: runmodule.ReplaceResource("memory-reservation", max),
+				})
+			}
+		}
+	}
+	return excesses
+}
+
+// isZeroResource checks for a memory of 0 (unlimited to docker) or
+// cpu-shares of 0 (the docker default)
+func isZeroResource(runFlags dockerflags.DockerRunCommandFlags, name string) bool {
+	values := dockerflags.RunFlagValues(runFlags, name)
+	if len(values) == 0 {
+		return false
+	}
+	var value int64
+	var err error
+	switch name {
+	case "memory":
+		value, err = dockerflags.ParseSize(values[0])
+	case "cpu-shares":
+		value, err = strconv.ParseInt(strings.TrimSpace(values[0]), 10, 64)
+	default:
+		return false
+	}
+	return err == nil && value == 0
+}
+
+// checkNofileLimit checks the nofile ulimit of a run, which docker leaves at
+// the daemon default when not given
+func checkNofileLimit(runFlags dockerflags.DockerRunCommandFlags, max int64) *resourceExcess {
+	ulimit, set, _ := dockerflags.RunUlimit(runFlags, NofileUlimit)
+	if !set {
+		return nil
+	}
+	if ulimit.Hard != -1 && ulimit.Hard <= max && ulimit.Soft != -1 {
+		return nil
+	}
+
+	clamped := dockerflags.Ulimit{Name: NofileUlimit, Soft: ulimit.Soft, Hard: max}
+	if clamped.Soft == -1 || clamped.Soft > max {
+		clamped.Soft = max
+	}
+	ulimits := []string{}
+	for _, value := range runFlags.Ulimit {
+		if parsed, err := dockerflags.ParseUlimit(value); err == nil && parsed.Name == NofileUlimit {
+			continue
+		}
+		ulimits = append(ulimits, value)
+	}
+	return &resourceExcess{
+		reason:   fmt.Sprintf("--ulimit %s is over the limit of %d", ulimit, max),
+		mutation: runmodule.ReplaceFlag("ulimit", append(ulimits, clamped.String())...),
+	}
+}
+
+// ********************
+
+// ResourceLimitsRunModule clamps or denies runs over their resource limits
+type ResourceLimitsRunModule struct {
+	DefaultRunModule
+	config ResourceLimitsConfig
+}
+
+// NewResourceLimitsRunModule creates the run module for the limits config
+func NewResourceLimitsRunModule(config ResourceLimitsConfig) *ResourceLimitsRunModule {
+	if config.Action == "" {
+		config.Action = LimitActionClamp
+	}
+	if config.Action != LimitActionClamp && config.Action != LimitActionDeny {
+		log.Printf("WARN: unknown resource limits action %q, using %q", config.Action, LimitActionDeny)
+		config.Action = LimitActionDeny
+	}
+	return &ResourceLimitsRunModule{
+		DefaultRunModule: DefaultRunModule{Name: "resource-limits", RunPriority: ResourceLimitsModulePriority, NoOptOut: true},
+		config:           config,
+	}
+}
+
+// DenyRun implements the RunDenier interface, for the deny action and for
+// values which cannot be clamped
+func (m *ResourceLimitsRunModule) DenyRun(ctx *runmodule.RunContext) string {
+	for _, excess := range checkLimits(ctx.RunFlags, m.config.runMaxes(ctx.MarathonAppId)) {
+		if excess.invalid || m.config.Action == LimitActionDeny {
+			return excess.reason
+		}
+	}
+	return ""
+}
+
+// MutateRun implements the RunMutator interface, for the clamp action
+func (m *ResourceLimitsRunModule) MutateRun(ctx *runmodule.RunContext) []runmodule.RunMutation {
+	if m.config.Action != LimitActionClamp {
+		return nil
+	}
+	mutations := []runmodule.RunMutation{}
+	for _, excess := range checkLimits(ctx.RunFlags, m.config.runMaxes(ctx.MarathonAppId)) {
+		if excess.invalid {
+			continue
+		}
+		log.Printf("INFO: clamping run of %q (MARATHON_APP_ID=%q): %s", ctx.Image.String(), ctx.MarathonAppId, excess.reason)
+		mutations = append(mutations, excess.mutation)
+	}
+	return mutations
+}
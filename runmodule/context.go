@@ -0,0 +1,196 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package runmodule
+
+// the per-invocation context of a docker run, handed to run modules so they
+// do not depend on package globals set while parsing
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+	"github.com/yp-engineering/docker-wrapper/imageref"
+)
+
+// env vars Mesos (and Marathon) pass to the container
+const (
+	MesosTaskEnv  = "MESOS_TASK_ID"
+	MarathonAppId = "MARATHON_APP_ID"
+)
+
+// schedulers we can tell apart by the env vars they pass to the container
+const (
+	FrameworkMarathon = "marathon"
+	FrameworkChronos  = "chronos"
+	FrameworkMesos    = "mesos" // some other framework, only MESOS_TASK_ID
+
+	ChronosJobEnv = "CHRONOS_JOB_NAME"
+)
+
+// RunContext is everything known about a single docker run (or create).
+// Modules may read all of it; the wrapper updates RunFlags, Provenance and
+// Image as modules mutate the run.
+type RunContext struct {
+	Flags    dockerflags.DockerFlags
+	RunFlags dockerflags.DockerRunCommandFlags
+
+	// where each run option came from, by long name, see FlagProvenance.
+	// Shared between shallow copies of the context, do not change it.
+	Provenance map[string]dockerflags.FlagProvenance
+
+	// parsed out of RunFlags.Args.Image
+	Image imageref.ImageRef
+
+	// from -e ENV vars
+	MesosTaskId   string
+	MarathonAppId string
+	Framework     string // FrameworkMarathon ..., "" if not run by Mesos
+
+	Host HostFacts
+
+	// the docker args as called (without "docker" itself) and the wrapper's
+	// own environment
+	Args    []string
+	Environ []string
+
+	// when the wrapper stops waiting for the module it is handed to, zero
+	// for no limit.  Anything the module starts should be stopped by then.
+	Deadline time.Time
+
+	// set when explaining: modules should change nothing outside the run,
+	// e.g. pull no images
+	DryRun bool
+}
+
+// HostFacts describes the host (the Mesos agent) the wrapper runs on
+type HostFacts struct {
+	Hostname    string
+	NumCPU      int
+	MemoryBytes int64 // total memory, 0 if unknown
+}
+
+// NewRunContext creates the context for a run parsed from args, on host
+func NewRunContext(args []string, parsed *dockerflags.ParsedArgs, host HostFacts) *RunContext {
+	runFlags := parsed.RunFlags
+	ctx := &RunContext{
+		Flags:         parsed.Flags,
+		RunFlags:      runFlags,
+		Provenance:    parsed.RunFlagProvenance,
+		MesosTaskId:   dockerflags.EnvValueLike(runFlags.Env, MesosTaskEnv),
+		MarathonAppId: dockerflags.EnvValueLike(runFlags.Env, MarathonAppId),
+		Host:          host,
+		Args:          append([]string{}, args...),
+		Environ:       os.Environ(),
+	}
+	ctx.Image, _ = imageref.ParseImageRef(runFlags.Args.Image)
+
+	switch {
+	case ctx.MarathonAppId != "":
+		ctx.Framework = FrameworkMarathon
+	case dockerflags.EnvValueLike(runFlags.Env, ChronosJobEnv+"=") != "":
+		ctx.Framework = FrameworkChronos
+	case ctx.MesosTaskId != "":
+		ctx.Framework = FrameworkMesos
+	}
+	return ctx
+}
+
+// Copy is a deep copy of the context, which can be read and changed
+// without touching the original, e.g. from another goroutine
+func (ctx *RunContext) Copy() *RunContext {
+	copied := *ctx
+	copied.Flags = dockerflags.CopyFlags(ctx.Flags)
+	copied.RunFlags = dockerflags.CopyRunFlags(ctx.RunFlags)
+	if ctx.Provenance != nil {
+		copied.Provenance = map[string]dockerflags.FlagProvenance{}
+		for name, flag := range ctx.Provenance {
+			flag.Positions = append([]int(nil), flag.Positions...)
+			copied.Provenance[name] = flag
+		}
+	}
+	copied.Args = append([]string(nil), ctx.Args...)
+	copied.Environ = append([]string(nil), ctx.Environ...)
+	return &copied
+}
+
+// EnvValue is the value of a -e KEY=value run option, "" if not set
+func (ctx *RunContext) EnvValue(key string) string {
+	return dockerflags.EnvValueLike(ctx.RunFlags.Env, key+"=")
+}
+
+// FlagProvenance is where the value of a run option came from, by its long
+// name (e.g. "net")
+func (ctx *RunContext) FlagProvenance(longName string) dockerflags.FlagProvenance {
+	return ctx.Provenance[longName]
+}
+
+// SetModuleProvenance records the run options changed by mutations as set
+// by module.  The original positions are kept.
+func (ctx *RunContext) SetModuleProvenance(module string, mutations []RunMutation) {
+	provenance := map[string]dockerflags.FlagProvenance{}
+	for name, flag := range ctx.Provenance {
+		provenance[name] = flag
+	}
+	for _, mutation := range mutations {
+		if mutation.Flag != "" {
+			provenance[mutation.Flag] = dockerflags.FlagProvenance{
+				Source:    dockerflags.FlagModule,
+				Module:    module,
+				Positions: ctx.Provenance[mutation.Flag].Positions,
+			}
+		}
+	}
+	ctx.Provenance = provenance
+}
+
+// SetRunFlags replaces the run flags, re-parsing the image
+func (ctx *RunContext) SetRunFlags(runFlags dockerflags.DockerRunCommandFlags) error {
+	image, err := imageref.ParseImageRef(runFlags.Args.Image)
+	if err != nil {
+		return err
+	}
+	ctx.RunFlags, ctx.Image = runFlags, image
+	return nil
+}
+
+// ********************
+
+// CurrentHostFacts looks up the hostname, CPUs and memory of this host
+func CurrentHostFacts() HostFacts {
+	hostname, _ := os.Hostname()
+	return HostFacts{
+		Hostname:    hostname,
+		NumCPU:      runtime.NumCPU(),
+		MemoryBytes: totalMemoryBytes("/proc/meminfo"),
+	}
+}
+
+// totalMemoryBytes reads MemTotal from meminfo, 0 if unknown
+func totalMemoryBytes(meminfo string) int64 {
+	file, err := os.Open(meminfo)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// MemTotal:       16318312 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
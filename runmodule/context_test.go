@@ -0,0 +1,82 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package runmodule
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+)
+
+func TestTotalMemoryBytes(t *testing.T) {
+	file, err := ioutil.TempFile("", "meminfo")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("MemTotal:       16318312 kB\nMemFree:         1024 kB\n")
+	file.Close()
+
+	assert.Equal(t, int64(16318312*1024), totalMemoryBytes(file.Name()))
+	assert.Equal(t, int64(0), totalMemoryBytes(filepath.Join(os.TempDir(), "no-such-meminfo")))
+}
+
+func TestRunContext_SetModuleProvenance(t *testing.T) {
+	parsed, err := dockerflags.Parse([]string{"run", "--privileged", "-m", "1g", "img"})
+	assert.NoError(t, err)
+	ctx := NewRunContext([]string{"run", "--privileged", "-m", "1g", "img"}, parsed, HostFacts{})
+	saved := *ctx
+
+	ctx.SetModuleProvenance("limits", []RunMutation{RemoveFlag("privileged"), AddFlag("pids-limit", "100"), ReplaceImage("other")})
+	assert.Equal(t, dockerflags.FlagProvenance{Source: dockerflags.FlagModule, Module: "limits", Positions: []int{1}}, ctx.FlagProvenance("privileged"))
+	assert.Equal(t, dockerflags.FlagProvenance{Source: dockerflags.FlagModule, Module: "limits"}, ctx.FlagProvenance("pids-limit"))
+	assert.Equal(t, dockerflags.FlagProvenance{Source: dockerflags.FlagExplicit, Positions: []int{2}}, ctx.FlagProvenance("memory"))
+
+	// copies made before are unchanged
+	assert.Equal(t, dockerflags.FlagExplicit, saved.FlagProvenance("privileged").Source)
+	assert.Equal(t, dockerflags.FlagUnset, saved.FlagProvenance("pids-limit").Source)
+}
+
+func TestRunContext_Copy(t *testing.T) {
+	args := []string{"-H", "unix:///run/docker.sock", "run", "-e", "A=1", "-m", "1g", "img", "sh", "-c", "true"}
+	parsed, err := dockerflags.Parse(args)
+	assert.NoError(t, err)
+	ctx := NewRunContext(args, parsed, HostFacts{})
+
+	copied := ctx.Copy()
+	assert.Equal(t, ctx, copied)
+
+	// changes to the copy's lists and maps do not show in the original
+	copied.Flags.Host[0] = "tcp://other:2375"
+	copied.RunFlags.Env[0] = "A=2"
+	copied.RunFlags.Args.CmdArgs[0] = "bash"
+	copied.Provenance["memory"].Positions[0] = 9
+	copied.Provenance["privileged"] = dockerflags.FlagProvenance{Source: dockerflags.FlagModule}
+	copied.Args[0] = "-D"
+	assert.Equal(t, []string{"unix:///run/docker.sock"}, ctx.Flags.Host)
+	assert.Equal(t, []string{"A=1"}, ctx.RunFlags.Env)
+	assert.Equal(t, []string{"sh", "-c", "true"}, ctx.RunFlags.Args.CmdArgs)
+	assert.Equal(t, []int{5}, ctx.FlagProvenance("memory").Positions)
+	assert.Equal(t, dockerflags.FlagUnset, ctx.FlagProvenance("privileged").Source)
+	assert.Equal(t, args, ctx.Args)
+}
+
+func TestInjectedRunMutations(t *testing.T) {
+	args := []string{"run", "--net=host", "-e", "A=1", "img"}
+	parsed, err := dockerflags.Parse(args)
+	assert.NoError(t, err)
+	ctx := NewRunContext(args, parsed, HostFacts{})
+
+	mutations, err := InjectedRunMutations(ctx, []string{"-e", "B=2", "--net", "bridge", "--privileged", "-m", "1g"})
+	assert.NoError(t, err)
+	assert.Equal(t, []RunMutation{ReplaceFlag("env", "B=2", "A=1"), ReplaceFlag("memory", "1g"), ReplaceFlag("privileged", "true")}, mutations)
+
+	_, err = InjectedRunMutations(ctx, []string{"-e"})
+	assert.Error(t, err)
+	_, err = InjectedRunMutations(ctx, []string{"--frobnicate"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package runmodule is the API between docker-wrapper and its run modules:
+// the interfaces a module implements, the RunContext it is handed, and the
+// RunMutations it can make to a docker run.
+package runmodule
+
+import (
+	"time"
+)
+
+// Module interface for docker wrapper Run modules
+//   - Priority()  - a way to set order of operation - sorted in ascending order for execution
+//   - HandleRun(ctx) - examine the run (image, flags, task/app ids ...) and
+//     return new docker run args to inject.  An error skips the module or
+//     denies the run, depending on its FailurePolicy()
+
+type RunModule interface {
+	Priority() int
+	HandleRun(*RunContext) ([]string, error)
+}
+
+// Optional interface for Run modules which enforce a policy
+//   - DenyRun(ctx) - return a reason to refuse the docker run, or "" to allow it.
+//     A denied run is never passed on to docker and the wrapper exits non-zero
+
+type RunDenier interface {
+	DenyRun(*RunContext) string
+}
+
+// Optional interface for Run modules which change the existing run arguments
+//   - MutateRun(ctx) - return changes to apply to the parsed run command.  The
+//     wrapper applies them to RunContext.RunFlags and re-serializes the run
+//     arguments, later modules see the changed flags
+
+type RunMutator interface {
+	MutateRun(*RunContext) []RunMutation
+}
+
+// Optional interface for Run modules to set how their failures are handled
+//   - Timeout() - how long the module may take for a run, 0 for the default
+//   - FailurePolicy() - what to do when the module errors, panics or times out
+//
+// A failed module never takes the wrapper down.  The config file can
+// override both per module name.
+
+type RunFailureHandler interface {
+	Timeout() time.Duration
+	FailurePolicy() FailurePolicy
+}
+
+// Optional interface for Run modules which must run before or after other
+// modules, by name (see NamedRunModule).  Unknown names are ignored, the
+// module may not be registered on this host.
+//   - RunAfter() - modules which must run first
+//   - RunBefore() - modules which must run later
+//
+// The config file can add to both per module name.
+
+type RunOrdering interface {
+	RunAfter() []string
+	RunBefore() []string
+}
+
+// Optional interface for Run modules with a name, used in the config file,
+// logs and explain output.  Modules without one go by their type.
+
+type NamedRunModule interface {
+	ModuleName() string
+}
+
+// Optional interface for Run modules which containers may or may not opt
+// out of (with an env var or label)
+//   - AllowsOptOut() - false for a policy containers must not avoid.
+//     Modules which can deny a run, or fail closed, never allow it unless
+//     the config file says so
+
+type RunOptOutPolicy interface {
+	AllowsOptOut() bool
+}
+
+// FailurePolicy is what the wrapper does when a module errors, panics or
+// times out
+type FailurePolicy int
+
+const (
+	FailOpen   FailurePolicy = iota // log the failure and skip the module
+	FailClosed                      // deny the run
+)
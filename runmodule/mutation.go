@@ -0,0 +1,199 @@
+// Copyright 2015 YP LLC.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package runmodule
+
+// structured changes to the parsed docker run command - lets modules remove
+// and rewrite existing run arguments, not just inject new ones
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
+)
+
+// MutationOp is the kind of change a RunMutation makes
+type MutationOp int
+
+const (
+	MutateAdd     MutationOp = iota // add value(s) to a run option
+	MutateRemove                    // remove a run option altogether
+	MutateReplace                   // replace all values of a run option
+	MutateImage                     // replace the image
+	MutateCmdArgs                   // replace the CMD args after the image
+)
+
+// RunMutation is a single change to the DockerRunCommandFlags.  Flag is the
+// long name of a run option (e.g. "memory", "privileged").
+type RunMutation struct {
+	Op     MutationOp
+	Flag   string
+	Values []string
+}
+
+// String describes the mutation for the explain output
+func (mutation RunMutation) String() string {
+	switch mutation.Op {
+	case MutateAdd:
+		return fmt.Sprintf("add --%s %q", mutation.Flag, mutation.Values)
+	case MutateRemove:
+		return fmt.Sprintf("remove --%s", mutation.Flag)
+	case MutateReplace:
+		return fmt.Sprintf("replace --%s %q", mutation.Flag, mutation.Values)
+	case MutateImage:
+		return fmt.Sprintf("replace image %q", strings.Join(mutation.Values, " "))
+	case MutateCmdArgs:
+		return fmt.Sprintf("replace cmd args %q", mutation.Values)
+	}
+	return fmt.Sprintf("unknown mutation %d", mutation.Op)
+}
+
+// MarshalText encodes the mutation as its description, e.g. for the audit log
+func (mutation RunMutation) MarshalText() ([]byte, error) {
+	return []byte(mutation.String()), nil
+}
+
+// AddFlag adds value(s) to a run option, single value options are set
+func AddFlag(flag string, values ...string) RunMutation {
+	return RunMutation{Op: MutateAdd, Flag: flag, Values: values}
+}
+
+// RemoveFlag removes a run option
+func RemoveFlag(flag string) RunMutation {
+	return RunMutation{Op: MutateRemove, Flag: flag}
+}
+
+// ReplaceFlag replaces all values of a run option
+func ReplaceFlag(flag string, values ...string) RunMutation {
+	return RunMutation{Op: MutateReplace, Flag: flag, Values: values}
+}
+
+// ReplaceResource replaces a resource run option (e.g. "memory",
+// "cpu-shares") with a number, in bytes for sizes
+func ReplaceResource(flag string, value int64) RunMutation {
+	return ReplaceFlag(flag, dockerflags.FormatResource(flag, value))
+}
+
+// ReplaceImage replaces the image to run
+func ReplaceImage(image string) RunMutation {
+	return RunMutation{Op: MutateImage, Values: []string{image}}
+}
+
+// ReplaceCmdArgs replaces the CMD args after the image
+func ReplaceCmdArgs(args ...string) RunMutation {
+	return RunMutation{Op: MutateCmdArgs, Values: args}
+}
+
+// InjectedRunMutations works out the changes args injected after "run" make
+// to the run, so the context can show them.  Injected args go before the
+// existing ones: list options keep both, a single value option only changes
+// if it was not given (or set by an earlier module).
+func InjectedRunMutations(ctx *RunContext, args []string) ([]RunMutation, error) {
+	image := ctx.RunFlags.Args.Image
+	parsed, err := dockerflags.Parse(append(append([]string{"run"}, args...), image))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.RunFlags.Args.Image != image || len(parsed.RunFlags.Args.CmdArgs) > 0 {
+		return nil, fmt.Errorf("docker-wrapper: injected args %q are not all run options", args)
+	}
+
+	names := []string{}
+	for name, provenance := range parsed.RunFlagProvenance {
+		if provenance.Source == dockerflags.FlagExplicit {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	mutations := []RunMutation{}
+	for _, name := range names {
+		field, _ := dockerflags.RunFlagField(reflect.ValueOf(parsed.RunFlags), name)
+		source := ctx.FlagProvenance(name).Source
+		switch {
+		case field.Kind() == reflect.Slice:
+			values := append(dockerflags.RunFlagValues(parsed.RunFlags, name), dockerflags.RunFlagValues(ctx.RunFlags, name)...)
+			mutations = append(mutations, ReplaceFlag(name, values...))
+		case source == dockerflags.FlagExplicit || (source == dockerflags.FlagModule && len(dockerflags.RunFlagValues(ctx.RunFlags, name)) > 0):
+			// the later value wins
+		case field.Kind() == reflect.Bool:
+			mutations = append(mutations, ReplaceFlag(name, strconv.FormatBool(field.Bool())))
+		default:
+			mutations = append(mutations, ReplaceFlag(name, field.String()))
+		}
+	}
+	return mutations, nil
+}
+
+// ********************
+
+// ApplyRunMutations changes runFlags in place, stopping at the first bad
+// mutation (e.g. an unknown option name)
+func ApplyRunMutations(runFlags *dockerflags.DockerRunCommandFlags, mutations []RunMutation) error {
+	for _, mutation := range mutations {
+		if err := applyRunMutation(runFlags, mutation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyRunMutation(runFlags *dockerflags.DockerRunCommandFlags, mutation RunMutation) error {
+	switch mutation.Op {
+	case MutateImage:
+		if len(mutation.Values) != 1 || mutation.Values[0] == "" {
+			return fmt.Errorf("docker-wrapper: replace image needs a single image, got %q", mutation.Values)
+		}
+		runFlags.Args.Image = mutation.Values[0]
+		return nil
+	case MutateCmdArgs:
+		runFlags.Args.CmdArgs = append([]string{}, mutation.Values...)
+		return nil
+	}
+
+	field, ok := dockerflags.RunFlagField(reflect.ValueOf(runFlags).Elem(), mutation.Flag)
+	if !ok {
+		return fmt.Errorf("docker-wrapper: unknown run option %q", mutation.Flag)
+	}
+
+	if mutation.Op == MutateRemove {
+		// back to as if never given
+		field.Set(reflect.Zero(field.Type()))
+		if field.Kind() == reflect.String {
+			field.SetString(dockerflags.RunFlagDefault(mutation.Flag))
+		}
+		return nil
+	}
+	if mutation.Op != MutateAdd && mutation.Op != MutateReplace {
+		return fmt.Errorf("docker-wrapper: unknown run mutation %d", mutation.Op)
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		// no value means set, otherwise "true" or "false"
+		field.SetBool(len(mutation.Values) == 0 || mutation.Values[len(mutation.Values)-1] != "false")
+	case reflect.String:
+		if len(mutation.Values) != 1 {
+			return fmt.Errorf("docker-wrapper: run option %q takes a single value, got %q", mutation.Flag, mutation.Values)
+		}
+		if dockerflags.IsResourceOption(mutation.Flag) {
+			if _, err := dockerflags.ParseResource(mutation.Flag, mutation.Values[0]); err != nil {
+				return err
+			}
+		}
+		field.SetString(mutation.Values[0])
+	case reflect.Slice:
+		if mutation.Op == MutateReplace {
+			field.Set(reflect.Zero(field.Type()))
+		}
+		for _, value := range mutation.Values {
+			field.Set(reflect.Append(field, reflect.ValueOf(value).Convert(field.Type().Elem())))
+		}
+	}
+	return nil
+}
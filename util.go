@@ -11,8 +11,11 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 	"syscall"
+
+	"github.com/yp-engineering/docker-wrapper/dockerflags"
 )
 
 const (
@@ -20,6 +23,9 @@ const (
 	// we can find the real docker binary in dockerDo
 	// e.g. /go/bin/docker-wrapper
 	SafeDockerSearchPath = "/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin"
+
+	// config patterns with this prefix are regular expressions, not globs
+	RegexpPatternPrefix = "re:"
 )
 
 // parseJsonFromString uses the generic interface{} to read arbitrary data
@@ -29,16 +35,45 @@ func parseJsonFromString(jsonstring string) (interface{}, error) {
 	return f, err
 }
 
+// matchPattern does a simple glob match of value against pattern, where '*'
+// matches any run of characters (including '/') and '?' a single character.
+// A pattern starting with "re:" is a regular expression matched against the
+// whole value instead.  An empty pattern matches anything.
+func matchPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	var re *regexp.Regexp
+	var err error
+	if strings.HasPrefix(pattern, RegexpPatternPrefix) {
+		re, err = regexp.Compile("^(?:" + strings.TrimPrefix(pattern, RegexpPatternPrefix) + ")$")
+	} else {
+		re, err = globRegexp(pattern, false)
+	}
+	if err != nil {
+		log.Printf("WARN: bad pattern %q: %v", pattern, err)
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// globRegexp compiles a glob pattern (see matchPattern) into an anchored
+// regexp, with capture groups for each '*' if capture is set
+func globRegexp(pattern string, capture bool) (*regexp.Regexp, error) {
+	star := ".*"
+	if capture {
+		star = "(.*)"
+	}
+	expr := strings.Replace(regexp.QuoteMeta(pattern), `\*`, star, -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
 // ---------------------------------------------------------------------------
 
-// check os.Args for the single word "run"
+// check docker args (without "docker" itself) for a run (or create) subcommand
 func simpleIsDockerRunCommand(args []string) bool {
-	for i := range args {
-		if args[i] == "run" {
-			return true
-		}
-	}
-	return false
+	return dockerflags.RunCommandIndex(args) != -1
 }
 
 // findBinary uses a restricted PATH to find an executable
@@ -56,7 +91,7 @@ func sh(name string, argv ...string) (string, error) {
 	}
 	cmd := exec.Command(binary, argv...)
 	if isDebugEnabled() {
-		log.Printf("DEBUG: sh CMD: %q", cmd)
+		log.Printf("DEBUG: sh CMD: %q", redactArgs(cmd.Args))
 	}
 	out, err := cmd.Output()
 	return strings.Trim(string(out), " \n"), err
@@ -80,7 +115,7 @@ func dockerExec(argv []string) {
 
 	if isDebugEnabled() {
 		log.Printf("DEBUG: docker binary: %s", dockerBinary)
-		log.Printf("DEBUG: docker args: %v", newArgs)
+		log.Printf("DEBUG: docker args: %q", redactArgs(newArgs))
 	}
 
 	dockerError := syscall.Exec(dockerBinary, newArgs, env)
@@ -89,38 +124,6 @@ func dockerExec(argv []string) {
 	}
 }
 
-// injectRunArgs takes the current argument list and another argument list to
-// inject after the "docker run" portions of the command arguments
-func injectRunArgs(args []string, inject_args []string) []string {
-	if inject_args == nil || len(inject_args) == 0 {
-		return args
-	}
-	newArgs := []string{}
-
-	if isDebugEnabled() {
-		log.Printf("DEBUG: request to inject args: %q", inject_args)
-	}
-
-	// find the run command argument index
-	runIndex := -1
-	for i := range args {
-		if args[i] == "run" {
-			runIndex = i
-		}
-	}
-	if runIndex == -1 {
-		// something funny - not docker run? just append all args
-		newArgs = args
-	} else {
-		// split the difference and merge in new args after "run"
-		newArgs = append(newArgs, args[0:runIndex+1]...)
-		newArgs = append(newArgs, inject_args...)
-		newArgs = append(newArgs, args[runIndex+1:]...)
-	}
-
-	return newArgs
-}
-
 ////////////////////////////////////////
 ////////////////////////////////////////
 
@@ -128,3 +131,9 @@ func injectRunArgs(args []string, inject_args []string) []string {
 func dockerInspect(name string) (string, error) {
 	return sh("docker", "inspect", name)
 }
+
+// dockerImageInspect inspects an image only, never a container or volume
+// which happens to have the same name, on the daemon dockerFlags connect to
+func dockerImageInspect(dockerFlags dockerflags.DockerFlags, image string) (string, error) {
+	return sh("docker", append(dockerflags.ConnectionArgs(dockerFlags), "image", "inspect", image)...)
+}